@@ -0,0 +1,350 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggregateFunctions lists the functions of `-aggregate-functions'.
+var aggregateFunctions = []string{"min", "max", "mean", "last", "count"}
+
+// aggregateFunc is a function to compute over each window and the suffix of
+// the field it is stored in. Percentiles, named p<percentile>, also hold
+// their quantile.
+type aggregateFunc struct {
+	name     string
+	suffix   string
+	quantile float64
+}
+
+// parseAggregateFuncs parses a comma separated list of functions, each
+// optionally followed by =<suffix>; the suffix defaults to _<function>.
+func parseAggregateFuncs(list string, percentiles bool) ([]aggregateFunc, error) {
+	var functions []aggregateFunc
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		fn := aggregateFunc{name: f, suffix: "_" + f}
+		if i := strings.Index(f, "="); i >= 0 {
+			fn.name, fn.suffix = f[:i], f[i+1:]
+		}
+
+		if percentiles {
+			p, err := strconv.ParseFloat(strings.TrimPrefix(fn.name, "p"), 64)
+			if !strings.HasPrefix(fn.name, "p") || err != nil || p <= 0 || p >= 100 {
+				return nil, fmt.Errorf("invalid percentile `%s', use p<percentile> e.g. p95", fn.name)
+			}
+			fn.quantile = p / 100
+		} else if !stringInSlice(fn.name, aggregateFunctions) {
+			return nil, fmt.Errorf("unknown aggregate function `%s', use %s", fn.name, strings.Join(aggregateFunctions, ", "))
+		}
+		functions = append(functions, fn)
+	}
+	return functions, nil
+}
+
+// derivedFields computes values that aren't collected as such, to track
+// their percentiles, from the previous and current raw fields of a series.
+var derivedFields = map[string]map[string]func(previous, current map[string]interface{}) (float64, bool){
+	"cpu":  {"busy": cpuBusy},
+	"cpus": {"busy": cpuBusy},
+}
+
+// cpuBusy returns the percentage of non-idle CPU time since the previous
+// collection. CPU times are cumulative and not diffed by the reporter,
+// since sigar reports them as unsigned values.
+func cpuBusy(previous, current map[string]interface{}) (float64, bool) {
+	var times [2][2]float64
+	for i, fields := range []map[string]interface{}{previous, current} {
+		for j, name := range []string{"total", "idle"} {
+			v, ok := numericValue(fields[name])
+			if !ok {
+				return 0, false
+			}
+			times[i][j] = v
+		}
+	}
+
+	total, idle := times[1][0]-times[0][0], times[1][1]-times[0][1]
+	if total <= 0 || idle < 0 {
+		return 0, false
+	}
+	return 100 * (total - idle) / total, true
+}
+
+// fieldAggregate accumulates the values of one field during a window.
+// Values that aren't numeric are only kept as last value.
+type fieldAggregate struct {
+	min, max, sum float64
+	count         int
+	last          interface{}
+}
+
+func (f *fieldAggregate) add(v interface{}) {
+	f.last = v
+
+	x, ok := numericValue(v)
+	if !ok {
+		return
+	}
+	if f.count == 0 || x < f.min {
+		f.min = x
+	}
+	if f.count == 0 || x > f.max {
+		f.max = x
+	}
+	f.sum += x
+	f.count++
+}
+
+func (f *fieldAggregate) value(function string) (interface{}, bool) {
+	if function == "last" {
+		return f.last, f.last != nil
+	}
+	if f.count == 0 {
+		return nil, false
+	}
+
+	switch function {
+	case "min":
+		return f.min, true
+	case "max":
+		return f.max, true
+	case "mean":
+		return f.sum / float64(f.count), true
+	case "count":
+		return f.count, true
+	}
+	return nil, false
+}
+
+type seriesAggregate struct {
+	name      string
+	tags      map[string]string
+	fields    map[string]*fieldAggregate
+	quantiles map[string][]*p2Quantile
+}
+
+// aggregator reduces the points collected during each window to one point
+// per series, with the selected functions of each field, and the selected
+// percentiles of some fields. Windows are aligned on multiples of their
+// duration and aggregated points are timestamped with the start of their
+// window.
+type aggregator struct {
+	window            time.Duration
+	functions         []aggregateFunc
+	percentiles       []aggregateFunc
+	percentileFields  map[string][]string
+	measurementSuffix string
+
+	start  time.Time
+	series map[string]*seriesAggregate
+	order  []string
+	// previous holds the last raw fields of each series, across windows.
+	previous map[string]map[string]interface{}
+}
+
+// newAggregator parses the lists of functions and percentiles, see
+// parseAggregateFuncs, and the comma separated list of
+// <measurement>.<field> to compute the percentiles of.
+func newAggregator(window time.Duration, functions, percentiles, percentileFields, measurementSuffix string) (*aggregator, error) {
+	a := &aggregator{
+		window:            window,
+		percentileFields:  make(map[string][]string),
+		measurementSuffix: measurementSuffix,
+		series:            make(map[string]*seriesAggregate),
+		previous:          make(map[string]map[string]interface{}),
+	}
+
+	var err error
+	if a.functions, err = parseAggregateFuncs(functions, false); err != nil {
+		return nil, err
+	}
+	if a.percentiles, err = parseAggregateFuncs(percentiles, true); err != nil {
+		return nil, err
+	}
+
+	for _, f := range strings.Split(percentileFields, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		i := strings.Index(f, ".")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid percentile field `%s', use <measurement>.<field>", f)
+		}
+		a.percentileFields[f[:i]] = append(a.percentileFields[f[:i]], f[i+1:])
+	}
+	return a, nil
+}
+
+// add accumulates the points of a collection made at now. Once now is past
+// the current window, the aggregates of that window are returned.
+func (a *aggregator) add(points []*influx.Point, now time.Time) []*influx.Point {
+	var done []*influx.Point
+
+	start := now.Truncate(a.window)
+	if a.start.IsZero() {
+		a.start = start
+	} else if start.After(a.start) {
+		done = a.flush()
+		a.start = start
+	}
+
+	for _, p := range points {
+		current, err := rawFields(p)
+		if err != nil {
+			log.WithError(err).Error("Cannot read fields.")
+			continue
+		}
+
+		key := seriesKey(p)
+		s, ok := a.series[key]
+		if !ok {
+			s = &seriesAggregate{
+				name:      p.Name(),
+				tags:      p.Tags(),
+				fields:    make(map[string]*fieldAggregate),
+				quantiles: make(map[string][]*p2Quantile),
+			}
+			a.series[key] = s
+			a.order = append(a.order, key)
+		}
+
+		previous := a.previous[key]
+		a.previous[key] = current
+		fields := windowFields(p.Name(), previous, current)
+
+		for k, v := range fields {
+			f, ok := s.fields[k]
+			if !ok {
+				f = &fieldAggregate{}
+				s.fields[k] = f
+			}
+			f.add(v)
+		}
+
+		if len(a.percentiles) > 0 {
+			a.addQuantiles(s, fields, previous, current)
+		}
+	}
+
+	return done
+}
+
+// windowFields returns the values to aggregate from the previous and current
+// raw fields of a series: gauges as collected, and counters as the increase
+// since the previous collection, whether or not the points are diffed. A
+// counter is skipped on the first collection of its series and when it was
+// reset.
+func windowFields(measurement string, previous, current map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(current))
+	for k, v := range current {
+		if !isCounter(measurement, k) {
+			fields[k] = v
+			continue
+		}
+
+		x, ok := numericValue(v)
+		last, found := numericValue(previous[k])
+		if ok && found && x >= last {
+			fields[k] = x - last
+		}
+	}
+	return fields
+}
+
+// addQuantiles adds the fields of a point to the percentiles of its series;
+// derived fields are computed from the previous and current raw fields.
+func (a *aggregator) addQuantiles(s *seriesAggregate, fields, previous, current map[string]interface{}) {
+	for _, name := range a.percentileFields[s.name] {
+		x, ok := numericValue(fields[name])
+		if derive, derived := derivedFields[s.name][name]; derived {
+			x, ok = 0, false
+			if previous != nil {
+				x, ok = derive(previous, current)
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		quantiles, found := s.quantiles[name]
+		if !found {
+			for _, fn := range a.percentiles {
+				quantiles = append(quantiles, newP2Quantile(fn.quantile))
+			}
+			s.quantiles[name] = quantiles
+		}
+		for _, q := range quantiles {
+			q.add(x)
+		}
+	}
+}
+
+// flush returns the aggregates of the current window and starts a new one.
+func (a *aggregator) flush() []*influx.Point {
+	var points []*influx.Point
+	for _, key := range a.order {
+		s := a.series[key]
+
+		fields := make(map[string]interface{})
+		for k, f := range s.fields {
+			for _, fn := range a.functions {
+				if v, ok := f.value(fn.name); ok {
+					fields[k+fn.suffix] = v
+				}
+			}
+		}
+		for k, quantiles := range s.quantiles {
+			for i, q := range quantiles {
+				if v, ok := q.value(); ok {
+					fields[k+a.percentiles[i].suffix] = v
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		point, err := influx.NewPoint(s.name+a.measurementSuffix, s.tags, fields, a.start)
+		if err != nil {
+			log.WithError(err).Error("Cannot create aggregated point.")
+			continue
+		}
+		points = append(points, point)
+	}
+
+	a.series = make(map[string]*seriesAggregate)
+	a.order = nil
+	return points
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"testing"
+	"time"
+)
+
+func newTestSample(t *testing.T, device string, fields map[string]interface{}) *influxClient.Point {
+	point, err := influxClient.NewPoint("test_agg", map[string]string{"device": device}, fields, time.Now())
+	if err != nil {
+		t.Fatal("Cannot create point:", err)
+	}
+	return point
+}
+
+func TestAggregator(t *testing.T) {
+	a, err := newAggregator(time.Minute, "min,max,mean=,last,count=_n", "", "", "_1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, v := range []float64{3, 1, 2} {
+		done := a.add([]*influxClient.Point{
+			newTestSample(t, "sda", map[string]interface{}{"io_ticks": v, "state": "up"}),
+		}, start.Add(time.Duration(10*i+5)*time.Second))
+		if done != nil {
+			t.Fatal("Expected no aggregate within the window, got", done)
+		}
+	}
+
+	done := a.add([]*influxClient.Point{
+		newTestSample(t, "sda", map[string]interface{}{"io_ticks": 10.0}),
+	}, start.Add(65*time.Second))
+	if len(done) != 1 {
+		t.Fatal("Expected 1 aggregated point, got", done)
+	}
+
+	p := done[0]
+	if p.Name() != "test_agg_1m" || p.Tags()["device"] != "sda" || !p.Time().Equal(start) {
+		t.Error("Unexpected aggregated point:", p)
+	}
+	fields, _ := p.Fields()
+	expected := map[string]interface{}{
+		"io_ticks_min":  1.0,
+		"io_ticks_max":  3.0,
+		"io_ticks":      2.0,
+		"io_ticks_last": 2.0,
+		"io_ticks_n":    int64(3),
+		"state_last":    "up",
+	}
+	if len(fields) != len(expected) {
+		t.Error("Expected fields", expected, "got", fields)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("Expected %s=%v, got %v", k, v, fields[k])
+		}
+	}
+
+	// The next window only holds the samples collected since
+	done = a.add(nil, start.Add(125*time.Second))
+	if len(done) != 1 {
+		t.Fatal("Expected 1 aggregated point, got", done)
+	}
+	fields, _ = done[0].Fields()
+	if fields["io_ticks_n"] != int64(1) || !done[0].Time().Equal(start.Add(time.Minute)) {
+		t.Error("Unexpected aggregate of the second window:", done)
+	}
+}
+
+func TestAggregatorUnknownFunction(t *testing.T) {
+	if _, err := newAggregator(time.Minute, "min,median", "", "", "_agg"); err == nil {
+		t.Error("Expected error for unknown function")
+	}
+	if _, err := newAggregator(time.Minute, "min", "p100", "cpu.busy", "_agg"); err == nil {
+		t.Error("Expected error for invalid percentile")
+	}
+	if _, err := newAggregator(time.Minute, "min", "p95", "busy", "_agg"); err == nil {
+		t.Error("Expected error for percentile field without measurement")
+	}
+}
+
+func TestAggregatorPercentiles(t *testing.T) {
+	a, err := newAggregator(time.Minute, "", "p50,p99=_99th", "cpu.busy,test_agg.io_ticks", "_agg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CPU times are cumulative unsigned values, as collected by sigar; the
+	// CPU is busy i% of the time between the samples i-1 and i.
+	start := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	total, idle := uint64(100000), uint64(90000)
+	for i := 0; i <= 50; i++ {
+		busy := float64(i)
+		if i > 0 {
+			total += 200
+			idle += uint64(200 - 2*busy)
+		}
+		cpu, err := influxClient.NewPoint("cpu", map[string]string{"cpuid": "all"},
+			map[string]interface{}{"total": total, "idle": idle}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.add([]*influxClient.Point{
+			cpu,
+			newTestSample(t, "sda", map[string]interface{}{"io_ticks": 10 * busy, "read_ios": busy}),
+		}, start.Add(time.Duration(i)*time.Second))
+	}
+
+	done := a.add(nil, start.Add(time.Minute))
+	if len(done) != 2 {
+		t.Fatal("Expected 2 aggregated points, got", done)
+	}
+
+	fields, _ := done[0].Fields()
+	if done[0].Name() != "cpu_agg" || len(fields) != 2 {
+		t.Error("Expected busy percentiles only, got", done[0])
+	}
+	if v := fields["busy_p50"].(float64); v < 24 || v > 26 {
+		t.Error("Expected busy_p50 close to 25, got", v)
+	}
+	if v := fields["busy_99th"].(float64); v < 45 || v > 50 {
+		t.Error("Expected busy_99th close to 50, got", v)
+	}
+
+	fields, _ = done[1].Fields()
+	if _, ok := fields["io_ticks_p50"]; !ok || len(fields) != 2 {
+		t.Error("Expected io_ticks percentiles only, got", done[1])
+	}
+}
+
+func TestAggregatorCounters(t *testing.T) {
+	a, err := newAggregator(time.Minute, "mean,last", "", "", "_agg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDisk := func(ios, inFlight int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"disks",
+			map[string]string{"fqdn": "koala", "device": "sdz"},
+			map[string]interface{}{"read_ios": ios, "in_flight": inFlight},
+			time.Now(),
+		)
+		return p
+	}
+
+	// Points are diffed as in daemon mode, yet counters are aggregated as
+	// the increase of their totals, and gauges as collected
+	diffFromLast(newDisk(0, 0))
+	start := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, ios := range []int{1000, 1500, 2500} {
+		p := diffFromLast(newDisk(ios, 2*i))
+		a.add([]*influxClient.Point{p}, start.Add(time.Duration(i)*time.Second))
+	}
+
+	done := a.add(nil, start.Add(time.Minute))
+	if len(done) != 1 {
+		t.Fatal("Expected 1 aggregated point, got", done)
+	}
+	fields, _ := done[0].Fields()
+	expected := map[string]interface{}{
+		"read_ios_mean":  750.0,
+		"read_ios_last":  1000.0,
+		"in_flight_mean": 2.0,
+		"in_flight_last": int64(4),
+	}
+	if len(fields) != len(expected) {
+		t.Error("Expected fields", expected, "got", fields)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("Expected %s=%v, got %v", k, v, fields[k])
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables holding credentials, used unless given as flags
+const (
+	usernameEnv = "INFLUX_USERNAME"
+	passwordEnv = "INFLUX_PASSWORD"
+	tokenEnv    = "INFLUX_TOKEN"
+)
+
+// credentials returns the username and the password or, with the v2 API,
+// the token. The secret file takes precedence over flags, flags given on
+// the command line over environment variables, and those over flag
+// defaults.
+func credentials() (string, string, error) {
+	username := flagOrEnv(usernameFlag, usernameEnv, "username", "u")
+
+	if secretFlag != "" {
+		data, err := ioutil.ReadFile(secretFlag)
+		if err != nil {
+			return "", "", err
+		}
+		return username, strings.Split(string(data), "\n")[0], nil
+	}
+
+	if influxAPIFlag == "v2" {
+		return username, flagOrEnv(tokenFlag, tokenEnv, "token"), nil
+	}
+	return username, flagOrEnv(passwordFlag, passwordEnv, "password", "p"), nil
+}
+
+// flagOrEnv returns value if one of the named flags was set, otherwise the
+// environment variable env if set, otherwise value.
+func flagOrEnv(value, env string, names ...string) string {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
+			}
+		}
+	})
+
+	if !set {
+		if v, ok := os.LookupEnv(env); ok {
+			return v
+		}
+	}
+	return value
+}
+
+// isUnauthorized reports whether a write failed because of the credentials,
+// i.e. was answered with 401 Unauthorized. Writes go through doWrite with
+// both the 1.x and 2.x APIs, so their errors carry the status code.
+func isUnauthorized(err error) bool {
+	e, ok := err.(*writeError)
+	return ok && e.status == http.StatusUnauthorized
+}
+
+// reloadingClient rebuilds the wrapped client when the secret file changes,
+// e.g. when Vault or Kubernetes rotate it, and when a write is rejected as
+// unauthorized.
+type reloadingClient struct {
+	path  string
+	build func() (influx.Client, error)
+
+	mutex   sync.RWMutex
+	client  influx.Client
+	modTime time.Time
+}
+
+func newReloadingClient(path string, build func() (influx.Client, error)) (*reloadingClient, error) {
+	c := &reloadingClient{path: path, build: build}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// watch polls the secret file for changes until the process exits.
+func (c *reloadingClient) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		info, err := os.Stat(c.path)
+		if err != nil {
+			log.WithError(err).Warn("Cannot watch secret file.")
+			continue
+		}
+
+		c.mutex.RLock()
+		changed := !info.ModTime().Equal(c.modTime)
+		c.mutex.RUnlock()
+
+		if changed {
+			log.Infof("Secret file %s changed, reconnecting.", c.path)
+			if err := c.reload(); err != nil {
+				log.WithError(err).Error("Cannot reload credentials.")
+			}
+		}
+	}
+}
+
+// reload re-reads the secret file and swaps in a new client.
+func (c *reloadingClient) reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.build()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	old := c.client
+	c.client = client
+	c.modTime = info.ModTime()
+	c.mutex.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (c *reloadingClient) current() influx.Client {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.client
+}
+
+func (c *reloadingClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	return c.current().Ping(timeout)
+}
+
+func (c *reloadingClient) Write(bp influx.BatchPoints) error {
+	err := c.current().Write(bp)
+	if err == nil || !isUnauthorized(err) {
+		return err
+	}
+
+	log.WithError(err).Warn("Write unauthorized, re-reading secret file.")
+	if rerr := c.reload(); rerr != nil {
+		log.WithError(rerr).Error("Cannot reload credentials.")
+		return err
+	}
+	return c.current().Write(bp)
+}
+
+func (c *reloadingClient) Query(q influx.Query) (*influx.Response, error) {
+	return c.current().Query(q)
+}
+
+func (c *reloadingClient) Close() error {
+	return c.current().Close()
+}
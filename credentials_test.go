@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsFromEnvironment(t *testing.T) {
+	defer os.Unsetenv(usernameEnv)
+	defer os.Unsetenv(passwordEnv)
+	os.Setenv(usernameEnv, "reporter")
+	os.Setenv(passwordEnv, "from-env")
+
+	username, password, err := credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "reporter" || password != "from-env" {
+		t.Errorf("Expected credentials from environment, got %s/%s", username, password)
+	}
+
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	ioutil.WriteFile(secret, []byte("from-file\n"), 0600)
+
+	defer func(s string) { secretFlag = s }(secretFlag)
+	secretFlag = secret
+	if _, password, _ := credentials(); password != "from-file" {
+		t.Error("Secret file should take precedence, got", password)
+	}
+}
+
+func TestReloadOnUnauthorized(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	ioutil.WriteFile(secret, []byte("old"), 0600)
+
+	valid := "old"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != valid {
+			http.Error(w, `{"error":"authorization failed"}`, http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	builds := 0
+	client, err := newReloadingClient(secret, func() (influxClient.Client, error) {
+		builds++
+		data, _ := ioutil.ReadFile(secret)
+		config := influxClient.HTTPConfig{Addr: server.URL, Username: "root", Password: string(data)}
+		client, err := influxClient.NewHTTPClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return newWriteClient(client, config, false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Write(newTestBatch(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate the credential
+	valid = "new"
+	ioutil.WriteFile(secret, []byte("new"), 0600)
+
+	if err := client.Write(newTestBatch(t)); err != nil {
+		t.Fatal("Write should succeed after re-reading the secret:", err)
+	}
+	if builds != 2 {
+		t.Error("Expected the client to be rebuilt once, got", builds-1)
+	}
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected bool
+	}{
+		{&writeError{status: http.StatusUnauthorized, msg: "401 Unauthorized"}, true},
+		{&writeError{status: http.StatusForbidden, msg: "403 Forbidden: authorization failed"}, false},
+		{&writeError{status: http.StatusBadRequest, msg: `400 Bad Request: unable to parse 'disks,port=4010'`}, false},
+		{errors.New("dial tcp 10.0.0.1:4010: connection refused"), false},
+	} {
+		if isUnauthorized(c.err) != c.expected {
+			t.Errorf("Expected isUnauthorized(%v) to be %v", c.err, c.expected)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpoint is one InfluxDB server of a multiClient, with its error tracking.
+type endpoint struct {
+	addr   string
+	client influx.Client
+
+	healthy   bool
+	errors    int
+	lastError error
+	lastCheck time.Time
+}
+
+// endpointStatus is a snapshot of an endpoint's state.
+type endpointStatus struct {
+	Addr      string `json:"addr"`
+	Healthy   bool   `json:"healthy"`
+	Errors    int    `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// multiClient spreads writes over several InfluxDB servers. In failover
+// mode, points are written to the first healthy endpoint; endpoints that
+// can't be reached or answer with a 5xx are pinged again after
+// checkInterval and used again once they respond, while points refused with
+// a 4xx are reported without failing over. In mirror mode, points are
+// written to all endpoints.
+type multiClient struct {
+	endpoints     []*endpoint
+	mirror        bool
+	checkInterval time.Duration
+
+	mutex sync.Mutex
+}
+
+func newMultiClient(endpoints []*endpoint, mode string, checkInterval time.Duration) (*multiClient, error) {
+	if mode != "failover" && mode != "mirror" {
+		return nil, fmt.Errorf("unknown hosts mode `%s', use failover or mirror", mode)
+	}
+
+	for _, e := range endpoints {
+		e.healthy = true
+	}
+
+	return &multiClient{
+		endpoints:     endpoints,
+		mirror:        mode == "mirror",
+		checkInterval: checkInterval,
+	}, nil
+}
+
+// Ping succeeds if at least one endpoint responds.
+func (c *multiClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	var errs []string
+	for _, e := range c.endpoints {
+		ti, version, err := e.client.Ping(timeout)
+		c.record(e, err)
+		if err == nil {
+			return ti, version, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", e.addr, err))
+	}
+	return 0, "", errors.New(strings.Join(errs, "; "))
+}
+
+func (c *multiClient) Write(bp influx.BatchPoints) error {
+	if c.mirror {
+		return c.writeAll(bp)
+	}
+
+	var errs []string
+	for _, e := range c.available() {
+		err := e.client.Write(bp)
+		c.record(e, err)
+		// Other endpoints would refuse the same data
+		if err == nil || !isEndpointFailure(err) {
+			return err
+		}
+		log.WithError(err).Warnf("Write to %s failed, failing over.", e.addr)
+		errs = append(errs, fmt.Sprintf("%s: %v", e.addr, err))
+	}
+
+	if len(errs) == 0 {
+		return errors.New("no healthy InfluxDB endpoint")
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// writeAll writes to every endpoint concurrently.
+func (c *multiClient) writeAll(bp influx.BatchPoints) error {
+	errs := make([]error, len(c.endpoints))
+
+	var wg sync.WaitGroup
+	for i, e := range c.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			errs[i] = e.client.Write(bp)
+			c.record(e, errs[i])
+		}(i, e)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.endpoints[i].addr, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Query runs on all endpoints in mirror mode and returns the first
+// response; otherwise it runs on the first healthy endpoint that answers.
+func (c *multiClient) Query(q influx.Query) (*influx.Response, error) {
+	endpoints := c.endpoints
+	if !c.mirror {
+		endpoints = c.available()
+	}
+
+	var resp *influx.Response
+	var errs []string
+	for _, e := range endpoints {
+		r, err := e.client.Query(q)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", e.addr, err))
+			continue
+		}
+		if resp == nil {
+			resp = r
+		}
+		if !c.mirror {
+			return resp, nil
+		}
+	}
+
+	if len(errs) > 0 {
+		return resp, errors.New(strings.Join(errs, "; "))
+	}
+	if resp == nil {
+		return nil, errors.New("no healthy InfluxDB endpoint")
+	}
+	return resp, nil
+}
+
+func (c *multiClient) Close() error {
+	var err error
+	for _, e := range c.endpoints {
+		if cerr := e.client.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// available returns the healthy endpoints in order, after re-checking the
+// failed ones whose check interval elapsed.
+func (c *multiClient) available() []*endpoint {
+	var available []*endpoint
+	for _, e := range c.endpoints {
+		c.mutex.Lock()
+		healthy, lastCheck := e.healthy, e.lastCheck
+		c.mutex.Unlock()
+
+		if !healthy && time.Since(lastCheck) >= c.checkInterval {
+			_, _, err := e.client.Ping(time.Second)
+			c.record(e, err)
+			if err == nil {
+				log.Infof("InfluxDB endpoint %s is healthy again.", e.addr)
+				healthy = true
+			}
+		}
+
+		if healthy {
+			available = append(available, e)
+		}
+	}
+	return available
+}
+
+func (c *multiClient) record(e *endpoint, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e.lastCheck = time.Now()
+	e.healthy = err == nil || !isEndpointFailure(err)
+	if err != nil {
+		e.errors++
+		e.lastError = err
+	}
+}
+
+// status returns a snapshot of all endpoints.
+func (c *multiClient) status() []endpointStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var status []endpointStatus
+	for _, e := range c.endpoints {
+		s := endpointStatus{Addr: e.addr, Healthy: e.healthy, Errors: e.errors}
+		if e.lastError != nil {
+			s.LastError = e.lastError.Error()
+		}
+		status = append(status, s)
+	}
+	return status
+}
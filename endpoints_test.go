@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	*httptest.Server
+	up     bool
+	refuse bool
+	writes int
+}
+
+func newTestServer() *testServer {
+	s := &testServer{up: true}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.up {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/write" {
+			s.writes++
+			if s.refuse {
+				http.Error(w, `{"error":"partial write: field type conflict"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return s
+}
+
+func newTestEndpoints(t *testing.T, servers ...*testServer) []*endpoint {
+	var endpoints []*endpoint
+	for _, s := range servers {
+		config := influxClient.HTTPConfig{Addr: s.URL}
+		client, err := influxClient.NewHTTPClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc, err := newWriteClient(client, config, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints = append(endpoints, &endpoint{addr: s.URL, client: wc})
+	}
+	return endpoints
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := newTestServer(), newTestServer()
+	defer primary.Close()
+	defer secondary.Close()
+
+	client, err := newMultiClient(newTestEndpoints(t, primary, secondary), "failover", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func() {
+		if err := client.Write(newTestBatch(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	primary.up = false
+	write()
+	primary.up = true
+	write()
+
+	if primary.writes != 2 || secondary.writes != 1 {
+		t.Errorf("Expected 2 writes to primary and 1 to secondary, got %d and %d", primary.writes, secondary.writes)
+	}
+
+	status := client.status()
+	if status[0].Errors != 1 || status[0].LastError == "" || !status[0].Healthy {
+		t.Error("Unexpected primary status", status[0])
+	}
+	if status[1].Errors != 0 {
+		t.Error("Unexpected secondary status", status[1])
+	}
+}
+
+func TestFailoverRefusedData(t *testing.T) {
+	primary, secondary := newTestServer(), newTestServer()
+	defer primary.Close()
+	defer secondary.Close()
+
+	client, _ := newMultiClient(newTestEndpoints(t, primary, secondary), "failover", time.Hour)
+
+	primary.refuse = true
+	if err := client.Write(newTestBatch(t)); err == nil {
+		t.Error("Expected refused points to be reported")
+	}
+	primary.refuse = false
+	if err := client.Write(newTestBatch(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if primary.writes != 2 || secondary.writes != 0 {
+		t.Errorf("Refused points shouldn't fail over, got %d and %d writes", primary.writes, secondary.writes)
+	}
+	if status := client.status(); !status[0].Healthy || status[0].Errors != 1 {
+		t.Error("Unexpected primary status", status[0])
+	}
+}
+
+func TestFailoverCheckInterval(t *testing.T) {
+	primary, secondary := newTestServer(), newTestServer()
+	defer primary.Close()
+	defer secondary.Close()
+
+	client, _ := newMultiClient(newTestEndpoints(t, primary, secondary), "failover", time.Hour)
+
+	primary.up = false
+	client.Write(newTestBatch(t))
+	primary.up = true
+	client.Write(newTestBatch(t))
+
+	if primary.writes != 0 || secondary.writes != 2 {
+		t.Errorf("Failed primary shouldn't be used before the check interval, got %d and %d writes", primary.writes, secondary.writes)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	first, second := newTestServer(), newTestServer()
+	defer first.Close()
+	defer second.Close()
+
+	client, err := newMultiClient(newTestEndpoints(t, first, second), "mirror", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Write(newTestBatch(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	second.up = false
+	if err := client.Write(newTestBatch(t)); err == nil {
+		t.Error("Mirror mode should report failed endpoints")
+	}
+
+	if first.writes != 2 || second.writes != 1 {
+		t.Errorf("Expected 2 and 1 writes, got %d and %d", first.writes, second.writes)
+	}
+}
+
+func TestHostsMode(t *testing.T) {
+	if _, err := newMultiClient(nil, "roundrobin", 0); err == nil {
+		t.Error("Unknown mode should be rejected")
+	}
+}
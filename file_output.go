@@ -0,0 +1,231 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rotatedSuffix is appended to the path of rotated files; it sorts
+// chronologically.
+const rotatedSuffix = "20060102T150405.000000000"
+
+// fileOutput appends formatted points to a file and rotates it by size or age.
+// Rotated files are renamed to <path>.<timestamp>, optionally gzipped, and
+// only the newest maxFiles of them are retained.
+type fileOutput struct {
+	path      string
+	format    formatter
+	precision string
+	maxSize   int64
+	maxAge    time.Duration
+	compress  bool
+	maxFiles  int
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newFileOutput(path string, format formatter, precision string, maxSize int64, maxAge time.Duration, compress bool, maxFiles int) (*fileOutput, error) {
+	o := &fileOutput{
+		path:      path,
+		format:    format,
+		precision: precision,
+		maxSize:   maxSize,
+		maxAge:    maxAge,
+		compress:  compress,
+		maxFiles:  maxFiles,
+	}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *fileOutput) String() string {
+	return "file"
+}
+
+func (o *fileOutput) Write(points []*influx.Point) error {
+	if o.maxAge > 0 && time.Since(o.opened) >= o.maxAge && o.size > 0 {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+	}
+
+	var b bytes.Buffer
+	if err := o.format(&b, points, o.precision); err != nil {
+		return err
+	}
+
+	n, err := o.file.Write(b.Bytes())
+	o.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if o.maxSize > 0 && o.size >= o.maxSize {
+		return o.rotate()
+	}
+	return nil
+}
+
+func (o *fileOutput) Close() error {
+	return o.file.Close()
+}
+
+func (o *fileOutput) open() error {
+	file, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	o.file = file
+	o.size = info.Size()
+	o.opened = time.Now()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one.
+func (o *fileOutput) rotate() error {
+	if err := o.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := o.path + "." + time.Now().Format(rotatedSuffix)
+	if err := os.Rename(o.path, rotated); err != nil {
+		return err
+	}
+
+	if err := o.open(); err != nil {
+		return err
+	}
+
+	if o.compress {
+		if err := gzipFile(rotated); err != nil {
+			log.WithError(err).Errorf("Cannot compress rotated file %s.", rotated)
+		}
+	}
+
+	return o.removeOldFiles()
+}
+
+// removeOldFiles deletes the oldest rotated files beyond maxFiles.
+func (o *fileOutput) removeOldFiles() error {
+	if o.maxFiles <= 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(o.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	var rotated []string
+	for _, m := range matches {
+		if isRotatedFile(o.path, m) {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > o.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// isRotatedFile is true if name is path followed by a rotatedSuffix
+// timestamp and an optional .gz, so that other files next to path are
+// never removed.
+func isRotatedFile(path, name string) bool {
+	if !strings.HasPrefix(name, path+".") {
+		return false
+	}
+	suffix := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), ".gz")
+	_, err := time.Parse(rotatedSuffix, suffix)
+	return err == nil
+}
+
+// gzipFile replaces path with a gzip-compressed path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// lineProtocolPrecision maps the precision names accepted on the command
+// line to the ones understood by Point.PrecisionString.
+func lineProtocolPrecision(precision string) (string, error) {
+	switch precision {
+	case "ns", "n":
+		return "n", nil
+	case "us", "u":
+		return "u", nil
+	case "ms", "s":
+		return precision, nil
+	}
+	return "", fmt.Errorf("unknown precision `%s'", precision)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPoints(t *testing.T, n int) []*influxClient.Point {
+	var points []*influxClient.Point
+	for i := 0; i < n; i++ {
+		point, err := influxClient.NewPoint(
+			"test_file",
+			map[string]string{"tag0": "val0"},
+			map[string]interface{}{"col0": i},
+			time.Unix(1, 500000000),
+		)
+		if err != nil {
+			t.Fatal("Cannot create point:", err)
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+func TestFileOutputPrecision(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.lp")
+
+	o, err := newFileOutput(path, formatLine, "s", 0, 0, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write(newTestPoints(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	o.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	if expected := "test_file,tag0=val0 col0=0i 1\ntest_file,tag0=val0 col0=1i 1\n"; string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
+
+func TestFileOutputRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.lp")
+	backup := path + ".bak"
+	if err := ioutil.WriteFile(backup, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := newFileOutput(path, formatLine, "n", 10, 0, true, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := o.Write(newTestPoints(t, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.Close()
+
+	if _, err := os.Stat(backup); err != nil {
+		t.Error("Expected other files next to the output to be kept:", err)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*.gz")
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 retained files, got %v", rotated)
+	}
+
+	for _, r := range rotated {
+		if !strings.HasSuffix(r, ".gz") {
+			t.Error("Rotated file should be compressed:", r)
+			continue
+		}
+		f, _ := os.Open(r)
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(zr)
+		f.Close()
+		if !strings.HasPrefix(string(data), "test_file,tag0=val0 col0=0i") {
+			t.Errorf("Unexpected content in %s: %q", r, data)
+		}
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Error("Expected a fresh, empty current file")
+	}
+}
+
+func TestLineProtocolPrecision(t *testing.T) {
+	for in, expected := range map[string]string{"ns": "n", "us": "u", "ms": "ms", "s": "s"} {
+		if p, err := lineProtocolPrecision(in); err != nil || p != expected {
+			t.Errorf("Expected %s for %s, got %s (%v)", expected, in, p, err)
+		}
+	}
+	if _, err := lineProtocolPrecision("d"); err == nil {
+		t.Error("Unknown precision should be rejected")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// formatter renders the points of one cycle for the stdout and file outputs.
+type formatter func(w io.Writer, points []*influx.Point, precision string) error
+
+var formatters = map[string]formatter{
+	"line":  formatLine,
+	"json":  formatJSON,
+	"table": formatTable,
+}
+
+func getFormatter(name string) (formatter, error) {
+	if f, ok := formatters[name]; ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown format `%s'", name)
+}
+
+// formatLine writes line protocol, one point per line.
+func formatLine(w io.Writer, points []*influx.Point, precision string) error {
+	for _, p := range points {
+		if _, err := fmt.Fprintln(w, p.PrecisionString(precision)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonPoint struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+func newJSONPoint(p *influx.Point, precision string) (jsonPoint, error) {
+	fields, err := formatFields(p)
+	if err != nil {
+		return jsonPoint{}, err
+	}
+	return jsonPoint{
+		Measurement: p.Name(),
+		Tags:        p.Tags(),
+		Fields:      fields,
+		Time:        roundTime(p.Time(), precision),
+	}, nil
+}
+
+// formatJSON writes an array of {measurement, tags, fields, time} objects,
+// as shown in the README.
+func formatJSON(w io.Writer, points []*influx.Point, precision string) error {
+	series := make([]jsonPoint, 0, len(points))
+	for _, p := range points {
+		jp, err := newJSONPoint(p, precision)
+		if err != nil {
+			return err
+		}
+		series = append(series, jp)
+	}
+
+	b, err := json.MarshalIndent(series, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// formatTable writes one aligned row per point.
+func formatTable(w io.Writer, points []*influx.Point, precision string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MEASUREMENT\tTAGS\tFIELDS\tTIME")
+
+	for _, p := range points {
+		fields, err := formatFields(p)
+		if err != nil {
+			return err
+		}
+
+		var tags []string
+		for k, v := range p.Tags() {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+
+		var values []string
+		for k, v := range fields {
+			values = append(values, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(values)
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name(), strings.Join(tags, ","),
+			strings.Join(values, " "), roundTime(p.Time(), precision).Format(time.RFC3339Nano))
+	}
+
+	return tw.Flush()
+}
+
+// formatFields returns the fields of p as written in line protocol, so that
+// all formats show the same values, with unsigned values as numbers rather
+// than the strings the InfluxDB client writes.
+func formatFields(p *influx.Point) (map[string]interface{}, error) {
+	fields, err := rawFields(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+				fields[k] = n
+			}
+		}
+	}
+	return fields, nil
+}
+
+// roundTime truncates t to a line protocol precision.
+func roundTime(t time.Time, precision string) time.Time {
+	switch precision {
+	case "u":
+		return t.Truncate(time.Microsecond)
+	case "ms":
+		return t.Truncate(time.Millisecond)
+	case "s":
+		return t.Truncate(time.Second)
+	}
+	return t
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := formatJSON(&b, newTestPoints(t, 1), "s"); err != nil {
+		t.Fatal(err)
+	}
+
+	var series []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &series); err != nil {
+		t.Fatal("Output is not a JSON array:", err)
+	}
+	if len(series) != 1 {
+		t.Fatal("Expected one point, got", len(series))
+	}
+
+	p := series[0]
+	if p["measurement"] != "test_file" {
+		t.Error("Unexpected measurement", p["measurement"])
+	}
+	if tags, ok := p["tags"].(map[string]interface{}); !ok || tags["tag0"] != "val0" {
+		t.Error("Unexpected tags", p["tags"])
+	}
+	if fields, ok := p["fields"].(map[string]interface{}); !ok || fields["col0"] != float64(0) {
+		t.Error("Unexpected fields", p["fields"])
+	}
+	if ts, _ := time.Parse(time.RFC3339Nano, p["time"].(string)); !ts.Equal(time.Unix(1, 0)) {
+		t.Error("Timestamp should be rounded to the precision, got", p["time"])
+	}
+}
+
+func TestFormatTable(t *testing.T) {
+	var b bytes.Buffer
+	if err := formatTable(&b, newTestPoints(t, 2), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header and 2 rows, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "MEASUREMENT") {
+		t.Error("Missing header:", lines[0])
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 4 || fields[1] != "tag0=val0" || fields[2] != "col0=1" {
+		t.Error("Unexpected row:", lines[2])
+	}
+}
+
+// TestFormatValues checks that every format shows the values written in
+// line protocol, with unsigned values as numbers.
+func TestFormatValues(t *testing.T) {
+	newNetwork := func(bytes int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"network",
+			map[string]string{"fqdn": "koala", "iface": "eth9"},
+			map[string]interface{}{"recv_bytes": bytes, "idle": uint64(1000)},
+			time.Unix(1, 0),
+		)
+		return p
+	}
+	diffFromLast(newNetwork(100))
+	points := []*influxClient.Point{diffFromLast(newNetwork(150))}
+
+	var b bytes.Buffer
+	if err := formatLine(&b, points, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if line := b.String(); !strings.Contains(line, "recv_bytes=150i") {
+		t.Error("Expected raw values in line protocol, got", line)
+	}
+
+	b.Reset()
+	if err := formatJSON(&b, points, "s"); err != nil {
+		t.Fatal(err)
+	}
+	var series []jsonPoint
+	if err := json.Unmarshal(b.Bytes(), &series); err != nil {
+		t.Fatal(err)
+	}
+	if fields := series[0].Fields; fields["recv_bytes"] != float64(150) || fields["idle"] != float64(1000) {
+		t.Error("Expected numeric raw values in JSON, got", fields)
+	}
+
+	b.Reset()
+	if err := formatTable(&b, points, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if table := b.String(); !strings.Contains(table, "idle=1000 recv_bytes=150") {
+		t.Error("Expected raw values in the table, got", table)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := getFormatter("xml"); err == nil {
+		t.Error("Unknown format should be rejected")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const graphiteTimeout = 5 * time.Second
+
+// graphiteOutput sends points to carbon using the plaintext protocol,
+// one `path value timestamp` line per field. Values are the ones written to
+// InfluxDB: counters are sent as totals, e.g. for nonNegativeDerivative().
+//
+// The path is built from the template, a dot separated list of: `measurement`,
+// `field`, the name of a tag, or `tags` for the values of all tags not named
+// elsewhere in the template, sorted by tag name. Characters other than
+// letters, digits, `-` and `_` are replaced in each path element.
+type graphiteOutput struct {
+	addr     string
+	prefix   string
+	template []string
+	replace  string
+
+	conn net.Conn
+}
+
+func newGraphiteOutput(addr, prefix, template, replace string) *graphiteOutput {
+	return &graphiteOutput{
+		addr:     addr,
+		prefix:   prefix,
+		template: strings.Split(template, "."),
+		replace:  replace,
+	}
+}
+
+func (o *graphiteOutput) String() string {
+	return "graphite"
+}
+
+func (o *graphiteOutput) Write(points []*influx.Point) error {
+	var b bytes.Buffer
+	for _, p := range points {
+		if err := o.format(&b, p); err != nil {
+			return err
+		}
+	}
+
+	// Retry once on a fresh connection, carbon may have closed the old one.
+	err := o.send(b.Bytes())
+	if err != nil {
+		log.WithError(err).Warn("Graphite connection lost, reconnecting.")
+		o.disconnect()
+		err = o.send(b.Bytes())
+	}
+	return err
+}
+
+func (o *graphiteOutput) Close() error {
+	o.disconnect()
+	return nil
+}
+
+func (o *graphiteOutput) send(b []byte) error {
+	if o.conn == nil {
+		conn, err := net.DialTimeout("tcp", o.addr, graphiteTimeout)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+
+	o.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	_, err := o.conn.Write(b)
+	return err
+}
+
+func (o *graphiteOutput) disconnect() {
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+}
+
+func (o *graphiteOutput) format(b *bytes.Buffer, p *influx.Point) error {
+	fields, err := rawFields(p)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ts := p.Time().Unix()
+	for _, k := range keys {
+		value, ok := numericValue(fields[k])
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(b, "%s %s %d\n", o.path(p.Name(), p.Tags(), k), strconv.FormatFloat(value, 'f', -1, 64), ts)
+	}
+	return nil
+}
+
+// path builds the metric path of a field following the template.
+func (o *graphiteOutput) path(measurement string, tags map[string]string, field string) string {
+	used := make(map[string]bool)
+	for _, t := range o.template {
+		used[t] = true
+	}
+
+	var elements []string
+	if o.prefix != "" {
+		elements = append(elements, o.prefix)
+	}
+
+	for _, t := range o.template {
+		switch t {
+		case "measurement":
+			elements = append(elements, o.sanitize(measurement))
+		case "field":
+			elements = append(elements, o.sanitize(field))
+		case "tags":
+			var keys []string
+			for k := range tags {
+				if !used[k] {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				elements = append(elements, o.sanitize(tags[k]))
+			}
+		default:
+			if v, ok := tags[t]; ok {
+				elements = append(elements, o.sanitize(v))
+			}
+		}
+	}
+
+	return strings.Join(elements, ".")
+}
+
+func (o *graphiteOutput) sanitize(element string) string {
+	var b bytes.Buffer
+	for _, r := range element {
+		if r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteString(o.replace)
+		}
+	}
+	return b.String()
+}
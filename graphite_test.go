@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGraphitePath(t *testing.T) {
+	tags := map[string]string{"fqdn": "koala.example.com", "disk": "/dev/sda1", "mountpoint": "/home"}
+
+	o := newGraphiteOutput("", "servers", "fqdn.measurement.tags.field", "_")
+	if path := o.path("mounts", tags, "free"); path != "servers.koala_example_com.mounts._dev_sda1._home.free" {
+		t.Error("Unexpected path", path)
+	}
+
+	o = newGraphiteOutput("", "", "measurement.mountpoint.field.fqdn", "-")
+	if path := o.path("mounts", tags, "free"); path != "mounts.-home.free.koala-example-com" {
+		t.Error("Unexpected path", path)
+	}
+}
+
+func TestGraphiteWrite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	lines := make(chan string)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			conn.Close()
+		}
+	}()
+
+	point, _ := influxClient.NewPoint(
+		"load",
+		map[string]string{"fqdn": "koala"},
+		map[string]interface{}{"one": 0.5},
+		time.Unix(1500000000, 0),
+	)
+
+	o := newGraphiteOutput(listener.Addr().String(), "", "fqdn.measurement.field", "_")
+	defer o.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := o.Write([]*influxClient.Point{point}); err != nil {
+			t.Fatal(err)
+		}
+		if line := <-lines; line != "koala.load.one 0.5 1500000000" {
+			t.Error("Unexpected line", line)
+		}
+
+		// Drop the connection, the next write has to reconnect
+		o.conn.Close()
+	}
+
+	// Counters are sent as totals, like to InfluxDB
+	newNetwork := func(bytes int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"network",
+			map[string]string{"fqdn": "koala", "iface": "eth7"},
+			map[string]interface{}{"recv_bytes": bytes},
+			time.Unix(1500000000, 0),
+		)
+		return p
+	}
+	for _, bytes := range []int{1000, 1500} {
+		if p := diffFromLast(newNetwork(bytes)); p != nil {
+			if err := o.Write([]*influxClient.Point{p}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if line := <-lines; line != "koala.network.recv_bytes 1500 1500000000" {
+		t.Error("Expected the counter total, got", line)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// writeClient sends writes to the 1.x /write endpoint itself, optionally
+// gzip-compressed, since the vendored client neither supports
+// Content-Encoding nor reports the HTTP status of a failed write. Ping and
+// Query are passed through to the wrapped client.
+type writeClient struct {
+	influx.Client
+
+	url        url.URL
+	username   string
+	password   string
+	useragent  string
+	compress   bool
+	httpClient *http.Client
+	fallback   gzipFallback
+}
+
+func newWriteClient(client influx.Client, config influx.HTTPConfig, compress bool) (*writeClient, error) {
+	u, err := url.Parse(config.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeClient{
+		Client:    client,
+		url:       *u,
+		username:  config.Username,
+		password:  config.Password,
+		useragent: config.UserAgent,
+		compress:  compress,
+		httpClient: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: &http.Transport{TLSClientConfig: config.TLSConfig},
+		},
+	}, nil
+}
+
+func (c *writeClient) Write(bp influx.BatchPoints) error {
+	if !c.compress {
+		_, err := c.write(bp, false)
+		return err
+	}
+	return c.fallback.write(func(compress bool) (int, error) {
+		return c.write(bp, compress)
+	})
+}
+
+// write posts the batch to the 1.x write endpoint and returns the HTTP
+// status code, or 0 if no response was received.
+func (c *writeClient) write(bp influx.BatchPoints, compress bool) (int, error) {
+	var body *bytes.Buffer
+	var err error
+	if compress {
+		body, err = encodeGzip(bp.Points(), bp.Precision())
+	} else {
+		body, err = encodeLines(bp.Points(), bp.Precision())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	u := c.url
+	u.Path = "write"
+	req, err := http.NewRequest("POST", u.String(), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "")
+	req.Header.Set("User-Agent", c.useragent)
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	params := req.URL.Query()
+	params.Set("db", bp.Database())
+	params.Set("rp", bp.RetentionPolicy())
+	params.Set("precision", bp.Precision())
+	params.Set("consistency", bp.WriteConsistency())
+	req.URL.RawQuery = params.Encode()
+
+	return doWrite(c.httpClient, req)
+}
+
+// gzipFallback stops compressing writes once a server has rejected a
+// compressed batch but accepted it uncompressed.
+type gzipFallback struct {
+	mutex    sync.Mutex
+	disabled bool
+}
+
+// write sends a batch with send, compressed unless the server doesn't
+// support it, and returns the error of the last attempt.
+func (f *gzipFallback) write(send func(compress bool) (int, error)) error {
+	f.mutex.Lock()
+	disabled := f.disabled
+	f.mutex.Unlock()
+
+	if disabled {
+		_, err := send(false)
+		return err
+	}
+
+	status, err := send(true)
+	if err == nil {
+		return nil
+	}
+
+	// Servers without gzip support either refuse the encoding or try to
+	// parse the compressed body as line protocol. Only stop compressing if
+	// the same batch is accepted uncompressed.
+	if status != http.StatusUnsupportedMediaType && status != http.StatusBadRequest {
+		return err
+	}
+	if _, plainErr := send(false); plainErr != nil {
+		return plainErr
+	}
+
+	log.WithError(err).Warn("Server rejected gzip-compressed write, falling back to uncompressed writes.")
+	f.mutex.Lock()
+	f.disabled = true
+	f.mutex.Unlock()
+	return nil
+}
+
+// encodeLines renders points as line protocol, one point per line.
+func encodeLines(points []*influx.Point, precision string) (*bytes.Buffer, error) {
+	var b bytes.Buffer
+	for _, p := range points {
+		if _, err := fmt.Fprintln(&b, p.PrecisionString(precision)); err != nil {
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+// encodeGzip renders points as line protocol and compresses the result.
+func encodeGzip(points []*influx.Point, precision string) (*bytes.Buffer, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+
+	for _, p := range points {
+		if _, err := fmt.Fprintln(zw, p.PrecisionString(precision)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// writeError is a write refused by the server.
+type writeError struct {
+	status int
+	msg    string
+}
+
+func (e *writeError) Error() string {
+	return e.msg
+}
+
+// isEndpointFailure reports whether a write failed because of the server
+// rather than the data: transport errors and 5xx responses.
+func isEndpointFailure(err error) bool {
+	if e, ok := err.(*writeError); ok {
+		return e.status >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// doWrite sends a write request and turns any non-success status into a
+// writeError carrying the response body.
+func doWrite(httpClient *http.Client, req *http.Request) (int, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, &writeError{resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, bytes.TrimSpace(body))}
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBatch(t *testing.T) influxClient.BatchPoints {
+	bp, _ := influxClient.NewBatchPoints(influxClient.BatchPointsConfig{Database: "test"})
+	point, err := influxClient.NewPoint(
+		"test_gzip",
+		map[string]string{"tag0": "val0"},
+		map[string]interface{}{"col0": 42},
+		time.Unix(1, 0),
+	)
+	if err != nil {
+		t.Fatal("Cannot create point:", err)
+	}
+	bp.AddPoint(point)
+	return bp
+}
+
+func newTestWriteClient(t *testing.T, addr string, compress bool) *writeClient {
+	config := influxClient.HTTPConfig{Addr: addr}
+	client, err := influxClient.NewHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := newWriteClient(client, config, compress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gz
+}
+
+func TestGzipWrite(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Error("Expected gzip content encoding, got", r.Header.Get("Content-Encoding"))
+		}
+		if db := r.URL.Query().Get("db"); db != "test" {
+			t.Error("Expected database test, got", db)
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(zr)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := newTestWriteClient(t, server.URL, true).Write(newTestBatch(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "test_gzip,tag0=val0 col0=42i 1000000000\n"; body != expected {
+		t.Errorf("Expected body %q, got %q", expected, body)
+	}
+}
+
+func TestGzipFallback(t *testing.T) {
+	compressed, plain := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			compressed++
+			http.Error(w, "unsupported encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		plain++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := newTestWriteClient(t, server.URL, true)
+	for i := 0; i < 2; i++ {
+		if err := client.Write(newTestBatch(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if compressed != 1 || plain != 2 {
+		t.Errorf("Expected 1 compressed and 2 plain writes, got %d and %d", compressed, plain)
+	}
+}
+
+func TestGzipNoFallbackOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := newTestWriteClient(t, server.URL, true)
+	err := client.Write(newTestBatch(t))
+	if err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Error("Expected database not found error, got", err)
+	}
+	if client.fallback.disabled {
+		t.Error("Compression shouldn't be disabled by unrelated errors")
+	}
+}
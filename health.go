@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthServer answers liveness and readiness probes from the statistics
+// kept by the collection loop:
+//   - /healthz fails if no collection completed within maxAge,
+//   - /readyz fails if an output can't be reached,
+//   - /status describes collectors and outputs as JSON.
+type healthServer struct {
+	collectors []collector
+	outputs    []output
+	stats      *telemetry
+	maxAge     time.Duration
+	started    time.Time
+}
+
+type collectorStatus struct {
+	Name      string `json:"name"`
+	Points    int    `json:"points"`
+	Errors    int    `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type outputStatus struct {
+	Name       string            `json:"name"`
+	Ready      bool              `json:"ready"`
+	LastWrite  *time.Time        `json:"last_write,omitempty"`
+	Errors     int               `json:"errors"`
+	LastError  string            `json:"last_error,omitempty"`
+	Connection *connectionStatus `json:"connection,omitempty"`
+	Endpoints  []endpointStatus  `json:"endpoints,omitempty"`
+}
+
+type healthStatus struct {
+	Version    string            `json:"version"`
+	Healthy    bool              `json:"healthy"`
+	Ready      bool              `json:"ready"`
+	LastCycle  *time.Time        `json:"last_cycle,omitempty"`
+	LastWrite  *time.Time        `json:"last_write,omitempty"`
+	Collectors []collectorStatus `json:"collectors"`
+	Outputs    []outputStatus    `json:"outputs"`
+}
+
+func newHealthServer(collectors []collector, outputs []output, stats *telemetry, maxAge time.Duration) *healthServer {
+	return &healthServer{
+		collectors: collectors,
+		outputs:    outputs,
+		stats:      stats,
+		maxAge:     maxAge,
+		started:    time.Now(),
+	}
+}
+
+func (h *healthServer) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := http.Serve(listener, h.handler()); err != nil {
+			log.WithError(err).Debug("Health listener stopped.")
+		}
+	}()
+
+	log.Infof("Serving health checks on %s", listener.Addr())
+	return nil
+}
+
+func (h *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.serveHealthz)
+	mux.HandleFunc("/readyz", h.serveReadyz)
+	mux.HandleFunc("/status", h.serveStatus)
+	return mux
+}
+
+func (h *healthServer) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	s := h.status()
+	if !s.Healthy {
+		since := h.started
+		if s.LastCycle != nil {
+			since = *s.LastCycle
+		}
+		http.Error(w, fmt.Sprintf("no collection completed since %s", since.Format(time.RFC3339)), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *healthServer) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	s := h.status()
+	if !s.Ready {
+		var failed []string
+		for _, o := range s.Outputs {
+			if !o.Ready {
+				failed = append(failed, o.Name)
+			}
+		}
+		http.Error(w, "unreachable outputs: "+strings.Join(failed, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *healthServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	s := h.status()
+	w.Header().Set("Content-Type", "application/json")
+	if !s.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s)
+}
+
+func (h *healthServer) status() healthStatus {
+	h.stats.mutex.Lock()
+	defer h.stats.mutex.Unlock()
+
+	s := healthStatus{Version: applicationVersion, Ready: true}
+
+	since := h.started
+	if !h.stats.lastCycle.IsZero() {
+		lastCycle := h.stats.lastCycle
+		s.LastCycle = &lastCycle
+		since = lastCycle
+	}
+	s.Healthy = time.Since(since) <= h.maxAge
+
+	for _, cl := range h.collectors {
+		cs := collectorStatus{Name: cl.name}
+		if stats, ok := h.stats.collectors[cl.name]; ok {
+			cs.Points = stats.points
+			cs.Errors = stats.errors
+			if stats.lastError != nil {
+				cs.LastError = stats.lastError.Error()
+			}
+		}
+		s.Collectors = append(s.Collectors, cs)
+	}
+
+	for _, o := range h.outputs {
+		out := outputStatus{Name: o.String(), Ready: true}
+		if stats, ok := h.stats.outputs[o.String()]; ok {
+			out.Errors = stats.errors
+			if stats.lastError != nil {
+				out.LastError = stats.lastError.Error()
+				out.Ready = false
+			}
+			if !stats.lastWrite.IsZero() {
+				lastWrite := stats.lastWrite
+				out.LastWrite = &lastWrite
+				if s.LastWrite == nil || lastWrite.After(*s.LastWrite) {
+					s.LastWrite = &lastWrite
+				}
+			}
+		}
+		if influxOut, ok := o.(*influxOutput); ok {
+			connection := influxOut.status()
+			out.Connection = &connection
+			out.Ready = connection.Connected
+			out.Endpoints = clientEndpoints(influxOut.client)
+		}
+		s.Ready = s.Ready && out.Ready
+		s.Outputs = append(s.Outputs, out)
+	}
+
+	return s
+}
+
+// clientEndpoints returns the state of each server behind an InfluxDB
+// client, or nil if it only connects to one.
+func clientEndpoints(client influx.Client) []endpointStatus {
+	switch c := client.(type) {
+	case *multiClient:
+		return c.status()
+	case *reloadingClient:
+		return clientEndpoints(c.current())
+	}
+	return nil
+}
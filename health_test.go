@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	stats := newTelemetry()
+	collectList := []collector{{name: "test_ok"}, {name: "test_err"}}
+	outputs := []output{&stdoutOutput{}, errorOutput{}}
+	h := newHealthServer(collectList, outputs, stats, time.Minute)
+
+	server := httptest.NewServer(h.handler())
+	defer server.Close()
+
+	get := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Within the first intervals, the reporter is alive and ready
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Error("Expected healthy reporter at startup, got", code)
+	}
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Error("Expected ready reporter at startup, got", code)
+	}
+
+	stats.collected(timedResult{collectionResult{nil, errors.New("collect failed")}, "test_err", 0})
+	stats.written(outputs[0], 0, nil)
+	stats.written(outputs[1], 0, outputs[1].Write(nil))
+	stats.cycleDone()
+
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Error("Expected unready reporter with a failed output, got", code)
+	}
+
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var s healthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Healthy || s.Ready || s.LastCycle == nil || s.LastWrite == nil {
+		t.Error("Unexpected status:", s)
+	}
+	if len(s.Collectors) != 2 || s.Collectors[1].LastError != "collect failed" {
+		t.Error("Expected last error of test_err, got", s.Collectors)
+	}
+	if len(s.Outputs) != 2 || !s.Outputs[0].Ready || s.Outputs[1].Ready || s.Outputs[1].Errors != 1 {
+		t.Error("Unexpected output status:", s.Outputs)
+	}
+
+	stats.mutex.Lock()
+	stats.lastCycle = time.Now().Add(-2 * time.Minute)
+	stats.mutex.Unlock()
+	if code := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Error("Expected unhealthy reporter without recent collection, got", code)
+	}
+}
+
+func TestHealthFailedCycle(t *testing.T) {
+	stats := newTelemetry()
+	failing := collector{name: "test_err", collect: func(res chan collectionResult) {
+		res <- collectionResult{nil, errors.New("collect failed")}
+	}}
+
+	collectionLoop([]collector{failing}, nil, stats, nil, nil)
+	if !stats.lastCycle.IsZero() {
+		t.Error("Expected no successful cycle when every collector failed, got", stats.lastCycle)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Delays between two connection attempts
+var (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// influxOutput sends points to InfluxDB. The server does not need to be up
+// when the reporter starts: it is pinged in the background with increasing
+// delays until it responds, and again whenever a write fails because it is
+// unreachable. Meanwhile, points are buffered up to bufferSize, dropping the
+// oldest ones, or dropped if the policy is "drop". If provisioning fails
+// while the server is reachable, the output can't be created at startup;
+// once started, provisioning is retried like the connection.
+type influxOutput struct {
+	client     influx.Client
+	policy     string
+	bufferSize int
+	// onConnect runs once after the first successful ping, e.g. to
+	// provision the database. It is retried until it succeeds.
+	onConnect func(influx.Client) error
+
+	mutex       sync.Mutex
+	connected   bool
+	connecting  bool
+	initialized bool
+	buffer      []*influx.Point
+	dropped     int
+	lastError   error
+	// provisionError is the last error of onConnect, cleared once it
+	// succeeded
+	provisionError error
+}
+
+// connectionStatus is a snapshot of the state of an influxOutput.
+type connectionStatus struct {
+	Connected bool   `json:"connected"`
+	Buffered  int    `json:"buffered"`
+	Dropped   int    `json:"dropped"`
+	LastError string `json:"last_error,omitempty"`
+	// ProvisionError is set while the server is reachable but can't be
+	// provisioned
+	ProvisionError string `json:"provision_error,omitempty"`
+}
+
+func newInfluxOutput(client influx.Client, policy string, bufferSize int, onConnect func(influx.Client) error) (*influxOutput, error) {
+	if policy != "buffer" && policy != "drop" {
+		return nil, fmt.Errorf("unknown buffer policy `%s', use buffer or drop", policy)
+	}
+
+	o := &influxOutput{
+		client:     client,
+		policy:     policy,
+		bufferSize: bufferSize,
+		onConnect:  onConnect,
+	}
+
+	if err := o.ping(); err != nil {
+		log.WithError(err).Warn("InfluxDB is not reachable, collecting anyway.")
+		o.lastError = err
+		o.reconnect()
+		return o, nil
+	}
+
+	if err := o.provision(); err != nil {
+		return nil, err
+	}
+	o.connected = true
+	return o, nil
+}
+
+func (o *influxOutput) String() string {
+	return "influxdb"
+}
+
+func (o *influxOutput) Write(points []*influx.Point) error {
+	o.mutex.Lock()
+	if !o.connected {
+		o.keep(points)
+		o.mutex.Unlock()
+		return nil
+	}
+	backlog := o.buffer
+	o.buffer = nil
+	o.mutex.Unlock()
+
+	// The backlog is sent on its own, so that points refused in a new batch
+	// don't take it with them
+	batches := [][]*influx.Point{backlog, points}
+	var refused error
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		err := send(o.client, batch)
+		if err == nil {
+			continue
+		}
+
+		// Keep the points if the server went away or failed, e.g. with a 503
+		// while restarting, drop them if it refused them
+		if isEndpointFailure(err) {
+			log.WithError(err).Warn("Lost connection to InfluxDB.")
+			o.mutex.Lock()
+			o.connected = false
+			o.lastError = err
+			for _, b := range batches[i:] {
+				o.keep(b)
+			}
+			o.mutex.Unlock()
+			o.reconnect()
+			return refused
+		}
+
+		o.mutex.Lock()
+		o.dropped += len(batch)
+		o.lastError = err
+		o.mutex.Unlock()
+		refused = err
+	}
+	return refused
+}
+
+func (o *influxOutput) Close() error {
+	if s := o.status(); s.Buffered > 0 {
+		log.Warnf("Discarding %d points that could not be sent to InfluxDB.", s.Buffered)
+	}
+	return o.client.Close()
+}
+
+func (o *influxOutput) status() connectionStatus {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	s := connectionStatus{Connected: o.connected, Buffered: len(o.buffer), Dropped: o.dropped}
+	if o.lastError != nil {
+		s.LastError = o.lastError.Error()
+	}
+	if o.provisionError != nil {
+		s.ProvisionError = o.provisionError.Error()
+	}
+	return s
+}
+
+// keep buffers points according to the policy; the caller holds the mutex.
+func (o *influxOutput) keep(points []*influx.Point) {
+	if o.policy == "drop" {
+		o.dropped += len(points)
+		return
+	}
+
+	o.buffer = append(o.buffer, points...)
+	if over := len(o.buffer) - o.bufferSize; over > 0 {
+		o.dropped += over
+		o.buffer = o.buffer[over:]
+	}
+}
+
+// reconnect starts pinging the server in the background, unless it is
+// already being done.
+func (o *influxOutput) reconnect() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.connecting {
+		return
+	}
+	o.connecting = true
+	go o.connect()
+}
+
+func (o *influxOutput) connect() {
+	delay := minReconnectDelay
+	for {
+		err := o.ping()
+		reachable := err == nil
+		if reachable {
+			if err = o.provision(); err == nil {
+				break
+			}
+		}
+
+		o.mutex.Lock()
+		o.lastError = err
+		buffered, dropped := len(o.buffer), o.dropped
+		o.mutex.Unlock()
+
+		entry := log.WithError(err).WithFields(log.Fields{"buffered": buffered, "dropped": dropped})
+		if reachable {
+			entry.Errorf("Cannot provision InfluxDB, retrying in %s.", delay)
+		} else {
+			entry.Warnf("InfluxDB is not reachable, retrying in %s.", delay)
+		}
+		time.Sleep(delay)
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	o.mutex.Lock()
+	o.connected = true
+	o.connecting = false
+	o.mutex.Unlock()
+}
+
+// ping checks that the server is reachable.
+func (o *influxOutput) ping() error {
+	ti, s, err := o.client.Ping(time.Second)
+	if err != nil {
+		return err
+	}
+	log.Infof("Connected to: %s; ping: %d; version: %s", hostFlag, ti, s)
+	return nil
+}
+
+// provision runs onConnect once, the first time the server is reachable,
+// until it succeeds.
+func (o *influxOutput) provision() error {
+	o.mutex.Lock()
+	initialized := o.initialized
+	o.mutex.Unlock()
+
+	if initialized || o.onConnect == nil {
+		return nil
+	}
+
+	err := o.onConnect(o.client)
+	o.mutex.Lock()
+	o.provisionError = err
+	o.initialized = err == nil
+	o.mutex.Unlock()
+	return err
+}
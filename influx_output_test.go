@@ -0,0 +1,258 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInfluxOutputBuffer(t *testing.T) {
+	defer func(d time.Duration) { minReconnectDelay = d }(minReconnectDelay)
+	minReconnectDelay = 10 * time.Millisecond
+
+	var mutex sync.Mutex
+	up, written := false, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !up {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/write" {
+			written++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL})
+	connects := 0
+	o, err := newInfluxOutput(client, "buffer", 3, func(influxClient.Client) error {
+		connects++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := o.Write(newTestPoints(t, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := o.status(); s.Connected || s.Buffered != 3 || s.Dropped != 1 {
+		t.Fatal("Expected 3 buffered and 1 dropped point, got", s)
+	}
+
+	mutex.Lock()
+	up = true
+	mutex.Unlock()
+
+	for i := 0; i < 100 && !o.status().Connected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !o.status().Connected {
+		t.Fatal("Output should reconnect")
+	}
+
+	if err := o.Write(newTestPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s := o.status(); s.Buffered != 0 || written != 2 || connects != 1 {
+		t.Error("Buffered points should be sent before the next write, got", s, written, connects)
+	}
+}
+
+func TestInfluxOutputServerError(t *testing.T) {
+	defer func(d time.Duration) { minReconnectDelay = d }(minReconnectDelay)
+	minReconnectDelay = 10 * time.Millisecond
+
+	var mutex sync.Mutex
+	failing, written := true, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path == "/write" {
+			if failing {
+				http.Error(w, `{"error":"timeout"}`, http.StatusInternalServerError)
+				return
+			}
+			written++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	o, err := newInfluxOutput(newTestWriteClient(t, server.URL, false), "buffer", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Write(newTestPoints(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if s := o.status(); s.Buffered != 2 || s.Dropped != 0 {
+		t.Fatal("Expected points to be kept on a server error, got", s)
+	}
+
+	mutex.Lock()
+	failing = false
+	mutex.Unlock()
+	for i := 0; i < 100 && !o.status().Connected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := o.Write(newTestPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s := o.status(); s.Buffered != 0 || s.Dropped != 0 || written != 2 {
+		t.Error("Expected the kept points to be written, got", s, written)
+	}
+}
+
+func TestInfluxOutputRefused(t *testing.T) {
+	defer func(d time.Duration) { minReconnectDelay = d }(minReconnectDelay)
+	minReconnectDelay = 10 * time.Millisecond
+
+	var mutex sync.Mutex
+	up, written := false, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !up {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/write" {
+			// Accept the backlog, refuse the new batch
+			if written++; written > 1 {
+				http.Error(w, `{"error":"partial write: field type conflict"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	o, err := newInfluxOutput(newTestWriteClient(t, server.URL, false), "buffer", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Write(newTestPoints(t, 3))
+
+	mutex.Lock()
+	up = true
+	mutex.Unlock()
+	for i := 0; i < 100 && !o.status().Connected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := o.Write(newTestPoints(t, 2)); err == nil {
+		t.Error("Expected refused points to be reported")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s := o.status(); !s.Connected || s.Buffered != 0 || s.Dropped != 2 || written != 2 {
+		t.Error("Expected the backlog to be written and 2 refused points to be dropped, got", s, written)
+	}
+}
+
+func TestInfluxOutputProvisioning(t *testing.T) {
+	defer func(d time.Duration) { minReconnectDelay = d }(minReconnectDelay)
+	minReconnectDelay = 10 * time.Millisecond
+
+	var mutex sync.Mutex
+	up := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !up {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL})
+	refuse := func(influxClient.Client) error {
+		return errors.New("cannot create database metrics: requires admin privilege")
+	}
+
+	// A reachable server that can't be provisioned stops the startup
+	if _, err := newInfluxOutput(client, "buffer", 10, refuse); err == nil {
+		t.Error("Expected provisioning error at startup")
+	}
+
+	// Once started, provisioning is retried and reported
+	mutex.Lock()
+	up = false
+	mutex.Unlock()
+	o, err := newInfluxOutput(client, "buffer", 10, refuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	up = true
+	mutex.Unlock()
+
+	for i := 0; i < 100 && o.status().ProvisionError == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := o.status(); s.Connected || s.ProvisionError == "" {
+		t.Error("Expected a provisioning error, got", s)
+	}
+}
+
+func TestInfluxOutputDrop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL})
+	o, err := newInfluxOutput(client, "drop", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Write(newTestPoints(t, 2))
+	if s := o.status(); s.Buffered != 0 || s.Dropped != 2 || s.LastError == "" {
+		t.Error("Expected 2 dropped points, got", s)
+	}
+
+	if _, err := newInfluxOutput(client, "keep", 10, nil); err == nil {
+		t.Error("Unknown policy should be rejected")
+	}
+}
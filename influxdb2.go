@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// influxV2Client writes to the /api/v2/write endpoint of InfluxDB 2.x. It
+// implements influx.Client so it can be used in place of the 1.x client.
+type influxV2Client struct {
+	url        url.URL
+	org        string
+	bucket     string
+	token      string
+	useragent  string
+	compress   bool
+	httpClient *http.Client
+	fallback   gzipFallback
+}
+
+func newInfluxV2Client(config influx.HTTPConfig, org, bucket, token string, compress bool) (*influxV2Client, error) {
+	u, err := url.Parse(config.Addr)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported protocol scheme: %s", u.Scheme)
+	}
+
+	tr := &http.Transport{TLSClientConfig: config.TLSConfig}
+	return &influxV2Client{
+		url:        *u,
+		org:        org,
+		bucket:     bucket,
+		token:      token,
+		useragent:  config.UserAgent,
+		compress:   compress,
+		httpClient: &http.Client{Timeout: config.Timeout, Transport: tr},
+	}, nil
+}
+
+// Ping checks the /health endpoint, which replaces /ping in InfluxDB 2.x.
+func (c *influxV2Client) Ping(timeout time.Duration) (time.Duration, string, error) {
+	now := time.Now()
+	u := c.url
+	u.Path = "health"
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("User-Agent", c.useragent)
+
+	httpClient := *c.httpClient
+	if timeout > 0 {
+		httpClient.Timeout = timeout
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return 0, "", fmt.Errorf("cannot decode health response (%s): %v", resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || health.Status != "pass" {
+		return 0, health.Version, fmt.Errorf("server is not healthy: %s %s", health.Status, health.Message)
+	}
+
+	return time.Since(now), health.Version, nil
+}
+
+func (c *influxV2Client) Write(bp influx.BatchPoints) error {
+	if !c.compress {
+		_, err := c.write(bp, false)
+		return err
+	}
+	return c.fallback.write(func(compress bool) (int, error) {
+		return c.write(bp, compress)
+	})
+}
+
+// write posts the batch to the 2.x write endpoint and returns the HTTP
+// status code, or 0 if no response was received.
+func (c *influxV2Client) write(bp influx.BatchPoints, compress bool) (int, error) {
+	var body *bytes.Buffer
+	var err error
+	if compress {
+		body, err = encodeGzip(bp.Points(), bp.Precision())
+	} else {
+		body, err = encodeLines(bp.Points(), bp.Precision())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	u := c.url
+	u.Path = "api/v2/write"
+	req, err := http.NewRequest("POST", u.String(), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", c.useragent)
+	req.Header.Set("Authorization", "Token "+c.token)
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	params := req.URL.Query()
+	params.Set("org", c.org)
+	params.Set("bucket", c.bucket)
+	params.Set("precision", v2Precision(bp.Precision()))
+	req.URL.RawQuery = params.Encode()
+
+	return doWrite(c.httpClient, req)
+}
+
+// Query is not supported: InfluxDB 2.x uses Flux instead of InfluxQL.
+func (c *influxV2Client) Query(q influx.Query) (*influx.Response, error) {
+	return nil, errors.New("queries are not supported by the InfluxDB 2.x output")
+}
+
+func (c *influxV2Client) Close() error {
+	return nil
+}
+
+// v2Precision maps 1.x precision names to the ones accepted by /api/v2/write.
+func v2Precision(precision string) string {
+	switch precision {
+	case "", "n", "ns":
+		return "ns"
+	case "u", "us":
+		return "us"
+	default:
+		return precision
+	}
+}
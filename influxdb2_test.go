@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxV2Write(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Error("Unexpected path", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Token secret" {
+			t.Error("Unexpected authorization header", auth)
+		}
+		q := r.URL.Query()
+		if q.Get("org") != "my-org" || q.Get("bucket") != "my-bucket" || q.Get("precision") != "ns" {
+			t.Error("Unexpected query", r.URL.RawQuery)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := newInfluxV2Client(influxClient.HTTPConfig{Addr: server.URL}, "my-org", "my-bucket", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Write(newTestBatch(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "test_gzip,tag0=val0 col0=42i 1000000000\n"; body != expected {
+		t.Errorf("Expected body %q, got %q", expected, body)
+	}
+}
+
+func TestInfluxV2GzipFallback(t *testing.T) {
+	compressed, plain := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			compressed++
+			http.Error(w, "unsupported encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		plain++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := newInfluxV2Client(influxClient.HTTPConfig{Addr: server.URL}, "my-org", "my-bucket", "secret", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.Write(newTestBatch(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if compressed != 1 || plain != 2 {
+		t.Errorf("Expected 1 compressed and 2 plain writes, got %d and %d", compressed, plain)
+	}
+}
+
+func TestInfluxV2Ping(t *testing.T) {
+	status := "pass"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Error("Unexpected path", r.URL.Path)
+		}
+		w.Write([]byte(`{"name":"influxdb","status":"` + status + `","version":"2.7.1"}`))
+	}))
+	defer server.Close()
+
+	client, err := newInfluxV2Client(influxClient.HTTPConfig{Addr: server.URL}, "", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, version, err := client.Ping(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "2.7.1" {
+		t.Error("Expected version 2.7.1, got", version)
+	}
+
+	status = "fail"
+	if _, _, err := client.Ping(time.Second); err == nil {
+		t.Error("Ping should fail on unhealthy server")
+	}
+}
@@ -28,7 +28,9 @@ import (
 	"fmt"
 	"github.com/cloudfoundry/gosigar"
 	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/models"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
@@ -37,7 +39,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
@@ -48,6 +49,58 @@ type collectionResult struct {
 
 type collectionFunc func(chan collectionResult)
 
+// collector describes one option of `-collect'; it reports a measurement of
+// the same name.
+type collector struct {
+	name    string
+	collect collectionFunc
+	// diffed is set if the points are diffed with the previous collection,
+	// so that the collector only reports data from its second collection
+	// on.
+	diffed bool
+	// tags and fields describe the points, see `list'; every point is
+	// also tagged with fqdn.
+	tags   []string
+	fields []field
+}
+
+var collectors = []collector{
+	{"cpu", cpu, true, []string{"cpuid"}, counterFields(fieldString, cpuCols...)},
+	{"cpus", cpus, true, []string{"cpuid"}, counterFields(fieldString, cpuCols...)},
+	{"mem", mem, false, nil, typedFields(fieldString, memCols...)},
+	{"swap", swap, false, nil, typedFields(fieldString, swapCols...)},
+	{"uptime", uptime, false, nil, typedFields(fieldFloat, uptimeCols...)},
+	{"load", load, false, nil, typedFields(fieldFloat, loadCols...)},
+	{"network", network, true, []string{"iface"}, counterFields(fieldInteger, networkCols...)},
+	{"disks", disks, true, []string{"device"}, withGauges(counterFields(fieldInteger, diskCols...), "in_flight")},
+	{"mounts", mounts, true, []string{"disk", "mountpoint"}, typedFields(fieldString, mountCols...)},
+}
+
+// Field names of each collector, in the order of the values passed to
+// colFields. Unsigned values, e.g. from sigar, are written as strings by the
+// InfluxDB client.
+var cpuCols = []string{"user", "nice", "sys", "idle", "wait", "total"}
+
+var memCols = []string{"free", "used", "actualfree", "actualused", "total"}
+
+var swapCols = []string{"free", "used", "total"}
+
+var uptimeCols = []string{"length"}
+
+var loadCols = []string{"one", "five", "fifteen"}
+
+var mountCols = []string{"free", "total"}
+
+var networkCols = []string{"recv_bytes", "recv_packets", "recv_errs", "recv_drop",
+	"recv_fifo", "recv_frame", "recv_compressed",
+	"recv_multicast", "trans_bytes", "trans_packets",
+	"trans_errs", "trans_drop", "trans_fifo",
+	"trans_colls", "trans_carrier", "trans_compressed"}
+
+var diskCols = []string{"read_ios", "read_merges", "read_sectors", "read_ticks",
+	"write_ios", "write_merges", "write_sectors", "write_ticks",
+	"in_flight", "io_ticks", "time_in_queue"}
+
 // Variables storing arguments flags
 const applicationVersion = "0.6.0-alpha"
 
@@ -65,9 +118,93 @@ var hostFlag string
 var usernameFlag string
 var passwordFlag string
 var secretFlag string
+var secretWatchIntervalFlag time.Duration
 var databaseFlag string
 
 var retentionPolicyFlag string
+var precisionFlag string
+var writePrecision = "n"
+var writeConsistencyFlag string
+var createDatabaseFlag bool
+var rpDurationFlag string
+var rpReplicationFlag int
+var rpDefaultFlag bool
+var gzipFlag bool
+
+var tlsCAFlag string
+var tlsCertFlag string
+var tlsKeyFlag string
+var tlsServerNameFlag string
+var insecureSkipVerifyFlag bool
+
+var bufferPolicyFlag string
+var bufferSizeFlag int
+
+var hostsModeFlag string
+var hostsCheckIntervalFlag time.Duration
+
+var influxAPIFlag string
+var orgFlag string
+var bucketFlag string
+var tokenFlag string
+
+var formatFlag string
+
+var prometheusListenFlag string
+
+var graphiteFlag string
+var graphitePrefixFlag string
+var graphiteTemplateFlag string
+var graphiteReplaceFlag string
+
+var openTSDBFlag string
+var openTSDBPrefixFlag string
+var openTSDBBatchSizeFlag int
+
+var statsDFlag string
+var statsDPrefixFlag string
+var statsDTagsFlag bool
+
+var mqttFlag string
+var mqttTopicFlag string
+var mqttQoSFlag int
+var mqttRetainFlag bool
+var mqttFormatFlag string
+var mqttClientIDFlag string
+var mqttUsernameFlag string
+var mqttPasswordFlag string
+var mqttTLSCAFlag string
+var mqttTLSCertFlag string
+var mqttTLSKeyFlag string
+
+var otlpFlag string
+var otlpHeadersFlag string
+
+var fileFlag string
+var filePrecisionFlag string
+var fileRotateSizeFlag int64
+var fileRotateIntervalFlag time.Duration
+var fileCompressFlag bool
+var fileMaxFilesFlag int
+
+var aggregateWindowFlag time.Duration
+var aggregateFunctionsFlag string
+var aggregatePercentilesFlag string
+var aggregatePercentileFieldsFlag string
+var aggregateSuffixFlag string
+
+var recordFlag string
+var replayFlag string
+
+var rulesFlag string
+var alertWebhookFlag string
+var alertCommandFlag string
+var alertRetriesFlag int
+var alertRenotifyFlag time.Duration
+
+var selfTelemetryFlag bool
+var healthListenFlag string
+var healthIntervalsFlag int
 
 func init() {
 	flag.BoolVar(&versionFlag, "version", false, "Print the version number and exit.")
@@ -78,18 +215,101 @@ func init() {
 
 	flag.BoolVar(&sslFlag, "ssl", false, "Enable SSL/TLS encryption.")
 	flag.BoolVar(&sslFlag, "S", false, "Enable SSL/TLS encryption (shorthand).")
-	flag.StringVar(&hostFlag, "host", "localhost:8086", "Connect to host.")
-	flag.StringVar(&hostFlag, "h", "localhost:8086", "Connect to host (shorthand).")
+	flag.StringVar(&hostFlag, "host", "localhost:8086", "Connect to host; a comma separated list for several servers.")
+	flag.StringVar(&hostFlag, "h", "localhost:8086", "Connect to host; a comma separated list for several servers (shorthand).")
 	flag.StringVar(&usernameFlag, "username", "root", "User for login.")
 	flag.StringVar(&usernameFlag, "u", "root", "User for login (shorthand).")
 	flag.StringVar(&passwordFlag, "password", "root", "Password to use when connecting to server.")
 	flag.StringVar(&passwordFlag, "p", "root", "Password to use when connecting to server (shorthand).")
 	flag.StringVar(&secretFlag, "secret", "", "Absolute path to password file (shorthand). '-p' is ignored if specifed.")
 	flag.StringVar(&secretFlag, "s", "", "Absolute path to password file. '-p' is ignored if specifed.")
+	flag.DurationVar(&secretWatchIntervalFlag, "secret-watch-interval", 10*time.Second, "With a password file, how often to check it for changes.")
 	flag.StringVar(&databaseFlag, "database", "", "Name of the database to use.")
 	flag.StringVar(&databaseFlag, "d", "", "Name of the database to use (shorthand).")
 	flag.StringVar(&retentionPolicyFlag, "retentionpolicy", "", "Name of the retention policy to use.")
 	flag.StringVar(&retentionPolicyFlag, "rp", "", "Name of the retention policy to use (shorthand).")
+	flag.StringVar(&precisionFlag, "precision", "ns", "Precision of the timestamps sent to InfluxDB: s, ms, us or ns.")
+	flag.StringVar(&writeConsistencyFlag, "write-consistency", "", "Write consistency for clustered InfluxDB: any, one, quorum or all.")
+	flag.BoolVar(&createDatabaseFlag, "create-database", false, "At startup, create the database and retention policy if they don't exist and check write permissions.")
+	flag.StringVar(&rpDurationFlag, "rp-duration", "INF", "With -create-database, duration of the created retention policy, e.g. 30d.")
+	flag.IntVar(&rpReplicationFlag, "rp-replication", 1, "With -create-database, replication factor of the created retention policy.")
+	flag.BoolVar(&rpDefaultFlag, "rp-default", false, "With -create-database, make the created retention policy the default one.")
+	flag.BoolVar(&gzipFlag, "gzip", false, "Compress writes with gzip; falls back to plain writes if the server rejects them.")
+	flag.StringVar(&tlsCAFlag, "tls-ca", "", "With SSL/TLS, CA bundle to verify the server certificate.")
+	flag.StringVar(&tlsCertFlag, "tls-cert", "", "With SSL/TLS, client certificate for mutual TLS.")
+	flag.StringVar(&tlsKeyFlag, "tls-key", "", "With SSL/TLS, client certificate key for mutual TLS.")
+	flag.StringVar(&tlsServerNameFlag, "tls-server-name", "", "With SSL/TLS, server name to verify instead of the host name.")
+	flag.BoolVar(&insecureSkipVerifyFlag, "insecure-skip-verify", false, "With SSL/TLS, skip verification of the server certificate (lab servers only).")
+
+	flag.StringVar(&bufferPolicyFlag, "buffer-policy", "buffer", "While InfluxDB is unreachable, buffer points or drop them.")
+	flag.IntVar(&bufferSizeFlag, "buffer-size", 10000, "While InfluxDB is unreachable, maximum number of buffered points; the oldest ones are dropped.")
+
+	flag.StringVar(&hostsModeFlag, "hosts-mode", "failover", "With several hosts, failover (write to the first healthy one) or mirror (write to all).")
+	flag.DurationVar(&hostsCheckIntervalFlag, "hosts-check-interval", 30*time.Second, "With several hosts in failover mode, time before a failed host is checked again.")
+
+	flag.StringVar(&influxAPIFlag, "influx-api", "v1", "InfluxDB write API to use: v1 (database/retention policy) or v2 (org/bucket/token).")
+	flag.StringVar(&orgFlag, "org", "", "With the v2 API, name of the organization to write to.")
+	flag.StringVar(&bucketFlag, "bucket", "", "With the v2 API, name of the bucket to write to.")
+	flag.StringVar(&tokenFlag, "token", "", "With the v2 API, authentication token; read from the password file if specified.")
+
+	flag.StringVar(&formatFlag, "format", "line", "Format of points printed or written to file: line, json or table.")
+
+	flag.StringVar(&prometheusListenFlag, "prometheus-listen", "", "Expose the latest points for Prometheus at http://<address>/metrics, e.g. :9273.")
+
+	flag.StringVar(&graphiteFlag, "graphite", "", "Send points to this Graphite/carbon plaintext address, e.g. localhost:2003.")
+	flag.StringVar(&graphitePrefixFlag, "graphite-prefix", "", "With Graphite output, prefix of all metric paths.")
+	flag.StringVar(&graphiteTemplateFlag, "graphite-template", "fqdn.measurement.tags.field", "With Graphite output, order of the metric path: measurement, field, a tag name, or tags for the remaining tag values.")
+	flag.StringVar(&graphiteReplaceFlag, "graphite-replace", "_", "With Graphite output, replacement for characters not allowed in path elements.")
+
+	flag.StringVar(&openTSDBFlag, "opentsdb", "", "Send points to OpenTSDB: tcp://host:4242 for the telnet protocol, http(s)://host:4242 for the HTTP API.")
+	flag.StringVar(&openTSDBPrefixFlag, "opentsdb-prefix", "", "With OpenTSDB output, prefix of all metric names.")
+	flag.IntVar(&openTSDBBatchSizeFlag, "opentsdb-batch-size", 50, "With OpenTSDB HTTP output, maximum number of data points per request.")
+
+	flag.StringVar(&statsDFlag, "statsd", "", "Send points to this StatsD address over UDP, e.g. localhost:8125.")
+	flag.StringVar(&statsDPrefixFlag, "statsd-prefix", "", "With StatsD output, prefix of all metric names.")
+	flag.BoolVar(&statsDTagsFlag, "statsd-tags", false, "With StatsD output, send tags DogStatsD-style instead of adding their values to the metric names.")
+
+	flag.StringVar(&mqttFlag, "mqtt", "", "Publish points to this MQTT broker: tcp://host:1883, or ssl://host:8883 for TLS.")
+	flag.StringVar(&mqttTopicFlag, "mqtt-topic", "metrics/{fqdn}/{measurement}", "With MQTT output, topic template; {measurement} and {<tag>} are replaced.")
+	flag.IntVar(&mqttQoSFlag, "mqtt-qos", 0, "With MQTT output, QoS level: 0, 1 or 2.")
+	flag.BoolVar(&mqttRetainFlag, "mqtt-retain", false, "With MQTT output, ask the broker to retain the last message of each topic.")
+	flag.StringVar(&mqttFormatFlag, "mqtt-format", "line", "With MQTT output, payload format: line or json.")
+	flag.StringVar(&mqttClientIDFlag, "mqtt-client-id", "", "With MQTT output, client identifier (defaults to influxdb_reporter-<fqdn>).")
+	flag.StringVar(&mqttUsernameFlag, "mqtt-username", "", "With MQTT output, user for login.")
+	flag.StringVar(&mqttPasswordFlag, "mqtt-password", "", "With MQTT output, password for login.")
+	flag.StringVar(&mqttTLSCAFlag, "mqtt-tls-ca", "", "With MQTT output over TLS, CA bundle to verify the broker.")
+	flag.StringVar(&mqttTLSCertFlag, "mqtt-tls-cert", "", "With MQTT output over TLS, client certificate.")
+	flag.StringVar(&mqttTLSKeyFlag, "mqtt-tls-key", "", "With MQTT output over TLS, client certificate key.")
+
+	flag.StringVar(&otlpFlag, "otlp", "", "Export points as OpenTelemetry metrics with OTLP/HTTP (JSON) to this endpoint, e.g. http://localhost:4318.")
+	flag.StringVar(&otlpHeadersFlag, "otlp-headers", "", "With OTLP output, comma separated key=value HTTP headers, e.g. for authentication.")
+
+	flag.StringVar(&fileFlag, "file", "", "Append points as line protocol to this file.")
+	flag.StringVar(&filePrecisionFlag, "file-precision", "ns", "With file output, timestamp precision: s, ms, us or ns.")
+	flag.Int64Var(&fileRotateSizeFlag, "file-rotate-size", 0, "With file output, rotate the file once it reaches this many bytes (0 to disable).")
+	flag.DurationVar(&fileRotateIntervalFlag, "file-rotate-interval", 0, "With file output, rotate the file after this duration (0s to disable).")
+	flag.BoolVar(&fileCompressFlag, "file-compress", false, "With file output, gzip rotated files.")
+	flag.IntVar(&fileMaxFilesFlag, "file-max-files", 0, "With file output, number of rotated files to keep (0 to keep all).")
+
+	flag.DurationVar(&aggregateWindowFlag, "aggregate-window", 0, "With daemon mode, only send aggregates of the values collected during windows of this duration, e.g. 60s (0s to disable).")
+	flag.StringVar(&aggregateFunctionsFlag, "aggregate-functions", "min,max,mean,last,count", "With aggregation, functions computed for each field; add =<suffix> to change the field suffix, _<function> by default.")
+	flag.StringVar(&aggregatePercentilesFlag, "aggregate-percentiles", "p50,p95,p99", "With aggregation, percentiles estimated for the fields of -aggregate-percentile-fields; add =<suffix> to change the field suffix.")
+	flag.StringVar(&aggregatePercentileFieldsFlag, "aggregate-percentile-fields", "cpu.busy,cpus.busy,disks.io_ticks", "With aggregation, comma separated <measurement>.<field> to estimate percentiles of; busy is the percentage of non-idle CPU time.")
+	flag.StringVar(&aggregateSuffixFlag, "aggregate-suffix", "_agg", "With aggregation, suffix of the aggregated measurements.")
+
+	flag.StringVar(&recordFlag, "record", "", "Append the /proc files and file system statistics read by each collection to this archive.")
+	flag.StringVar(&replayFlag, "replay", "", "Collect from an archive written with -record instead of the live system, one collection per recording, then exit.")
+
+	flag.StringVar(&rulesFlag, "rules", "", "File of alert rules evaluated on each collection; state changes are sent as alerts points.")
+	flag.StringVar(&alertWebhookFlag, "alert-webhook", "", "With alert rules, comma separated URLs to POST alert state changes to as JSON.")
+	flag.StringVar(&alertCommandFlag, "alert-command", "", "With alert rules, command run by sh on alert state changes, with the alert in ALERT_* environment variables.")
+	flag.IntVar(&alertRetriesFlag, "alert-retries", 3, "With alert notifications, number of retries of a failed notification.")
+	flag.DurationVar(&alertRenotifyFlag, "alert-renotify", 0, "With alert notifications, interval to notify again of alerts still firing (0s to disable).")
+
+	flag.BoolVar(&selfTelemetryFlag, "self-telemetry", false, "Add an influxdb_reporter measurement with statistics of the reporter itself to each collection.")
+
+	flag.StringVar(&healthListenFlag, "health-listen", "", "Serve /healthz, /readyz and /status at http://<address>, e.g. :8080.")
+	flag.IntVar(&healthIntervalsFlag, "health-intervals", 3, "With the health listener, number of intervals without a completed collection before /healthz fails.")
 
 	flag.StringVar(&collectFlag, "collect", "cpu,cpus,mem,swap,uptime,load,network,disks,mounts", "Chose which data to collect.")
 	flag.StringVar(&collectFlag, "c", "cpu,cpus,mem,swap,uptime,load,network,disks,mounts", "Chose which data to collect (shorthand).")
@@ -107,11 +327,33 @@ func init() {
 func main() {
 	flag.Parse() // Scan the arguments list
 
+	// Flags may also follow the command
+	command := flag.Arg(0)
+	if command != "" {
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
 	if versionFlag {
 		fmt.Println("Version:", applicationVersion)
 		return
 	}
 
+	switch command {
+	case "":
+	case "validate":
+		if !validate(os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	case "list":
+		if err := listCollectors(os.Stdout, formatFlag); err != nil {
+			log.Panic(err)
+		}
+		return
+	default:
+		log.Panicf("Unknown command `%s'\n", command)
+	}
+
 	if pidFile != "" {
 		pid := strconv.Itoa(os.Getpid())
 		if err := ioutil.WriteFile(pidFile, []byte(pid), 0644); err != nil {
@@ -123,35 +365,111 @@ func main() {
 		consistencyFactor = daemonConsistencyFlag.Seconds() / daemonIntervalFlag.Seconds()
 	}
 
+	if err := checkFlags(); err != nil {
+		log.Panic(err)
+	}
+
 	// Fill InfluxDB connection settings
 	dbClient := newDBClient()
 
 	// Build collect list
-	collectList := buildCollectionList()
+	collectList, err := buildCollectionList()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	outputs := buildOutputList(dbClient)
+	defer closeOutputs(outputs)
+
+	stats := newTelemetry()
+	if healthListenFlag != "" {
+		h := newHealthServer(collectList, outputs, stats, time.Duration(healthIntervalsFlag)*daemonIntervalFlag)
+		if err := h.listen(healthListenFlag); err != nil {
+			log.WithError(err).Panic("Unable to start health listener\n")
+		}
+	}
+
+	var agg *aggregator
+	if daemonFlag && aggregateWindowFlag > 0 {
+		if agg, err = newAggregator(aggregateWindowFlag, aggregateFunctionsFlag, aggregatePercentilesFlag, aggregatePercentileFieldsFlag, aggregateSuffixFlag); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if recordFlag != "" {
+		r, err := newRecordSource(recordFlag)
+		if err != nil {
+			log.WithError(err).Panic("Unable to open record archive\n")
+		}
+		defer r.Close()
+		source = r
+	} else if replayFlag != "" {
+		r, err := newReplaySource(replayFlag)
+		if err != nil {
+			log.WithError(err).Panic("Unable to open replay archive\n")
+		}
+		defer r.Close()
+		source = r
+	}
+
+	var rules *ruleEngine
+	if rulesFlag != "" {
+		r, err := loadRules(rulesFlag)
+		if err != nil {
+			log.Panic(err)
+		}
+		collected := make(map[string]bool)
+		for _, cl := range collectList {
+			collected[cl.name] = true
+		}
+		for _, rl := range r {
+			for _, f := range rl.fields {
+				if !collected[f.measurement] {
+					log.Warnf("Rule %s refers to %s, which is not collected; it will never fire.", rl.name, f.measurement)
+				}
+			}
+		}
+		rules = newRuleEngine(r)
+		if alertWebhookFlag != "" || alertCommandFlag != "" {
+			rules.notifier = newNotifier(alertWebhookFlag, alertCommandFlag, alertRetriesFlag, alertRenotifyFlag)
+		}
+	}
 
-	collectionLoop(collectList, dbClient)
+	collectionLoop(collectList, outputs, stats, agg, rules)
 
 }
 
-func collectionLoop(collectList []collectionFunc, client influx.Client) {
-	ch := make(chan collectionResult, len(collectList))
+func collectionLoop(collectList []collector, outputs []output, stats *telemetry, agg *aggregator, rules *ruleEngine) {
+	ch := make(chan timedResult, len(collectList))
 	// Without daemon mode, do at least one lap
 	first := true
-	for first || daemonFlag {
+	for first || daemonFlag || replayFlag != "" {
 		first = false
 
+		if err := source.begin(); err == io.EOF {
+			log.Info("End of replay.")
+			return
+		} else if err != nil {
+			log.WithError(err).Error("Cannot read sources.")
+		}
+
 		// Collect data
 		var data []*influx.Point
+		succeeded := 0
 
 		for _, cl := range collectList {
-			go cl(ch)
+			go runCollector(cl, ch)
 		}
 
 		for i := len(collectList); i > 0; i-- {
 			res := <-ch
+			stats.collected(res)
 			if res.err != nil {
 				log.WithError(res.err).Error("Error collecting points.")
-			} else if len(res.point) > 0 {
+				continue
+			}
+			succeeded++
+			if len(res.point) > 0 {
 				for _, v := range res.point {
 					if v != nil {
 						data = append(data, v)
@@ -168,99 +486,341 @@ func collectionLoop(collectList []collectionFunc, client influx.Client) {
 			}
 		}
 
-		if !first {
-			// Show data
-			if !first && (databaseFlag == "" || verboseFlag) {
-				for _, j := range data {
-					fmt.Printf("%s\n", j.String())
-				}
+		var events []alertEvent
+		if !first && rules != nil {
+			events = rules.evaluate(data, source.now())
+		}
+
+		if !first && agg != nil {
+			data = agg.add(data, source.now())
+		}
+
+		// Alerts aren't aggregated
+		for _, ev := range events {
+			if point, err := ev.point(); err == nil {
+				data = append(data, point)
+			} else {
+				log.WithError(err).Error("Cannot create alert point.")
+			}
+		}
+
+		if !first && (agg == nil || data != nil) {
+			if selfTelemetryFlag {
+				data = append(data, stats.points(outputs)...)
 			}
 
-			// Send data
-			if client != nil {
-				if err := send(client, data); err != nil {
-					log.WithError(err).Error("Error while sending data to influx db.")
+			// Show and send data
+			for _, o := range outputs {
+				start := time.Now()
+				err := o.Write(data)
+				stats.written(o, time.Since(start), err)
+				if err != nil {
+					log.WithError(err).Errorf("Error while writing data to %s.", o)
 				}
 			}
 		}
 
-		if daemonFlag || first {
+		// A cycle only counts for health when some collector worked
+		if !first && succeeded > 0 {
+			stats.cycleDone()
+		}
+
+		if err := source.end(); err != nil {
+			log.WithError(err).Error("Cannot record sources.")
+		}
+
+		// Replay as fast as possible
+		if (daemonFlag || first) && replayFlag == "" {
 			time.Sleep(daemonIntervalFlag)
 		}
 	}
 }
 
 func newDBClient() influx.Client {
+	if !influxEnabled() {
+		return nil
+	}
+
 	var client influx.Client
-	if databaseFlag != "" {
-		var proto string
-		if sslFlag {
-			proto = "https"
-		} else {
-			proto = "http"
+	if secretFlag != "" {
+		// Rebuild the client whenever the password file is rotated
+		rc, err := newReloadingClient(secretFlag, buildDBClient)
+		if err != nil {
+			log.Panic(err)
 		}
-		var u, _ = url.Parse(fmt.Sprintf("%s://%s/", proto, hostFlag))
-		config := influx.HTTPConfig{Addr: u.String(), Username: usernameFlag, UserAgent: "sysinfo_influxdb v" + applicationVersion}
+		go rc.watch(secretWatchIntervalFlag)
+		client = rc
+	} else {
+		var err error
+		if client, err = buildDBClient(); err != nil {
+			log.Panic(err)
+		}
+	}
 
-		// use secret file if present, fallback to CLI password arg
-		if secretFlag != "" {
-			data, err := ioutil.ReadFile(secretFlag)
-			if err != nil {
-				log.Panic(err)
+	return client
+}
+
+// buildDBClient creates a client for the configured hosts with the current
+// credentials.
+func buildDBClient() (influx.Client, error) {
+	username, password, err := credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []*endpoint
+	for _, host := range strings.Split(hostFlag, ",") {
+		e, err := newEndpoint(strings.TrimSpace(host), username, password)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	if len(endpoints) == 1 {
+		return endpoints[0].client, nil
+	}
+	return newMultiClient(endpoints, hostsModeFlag, hostsCheckIntervalFlag)
+}
+
+// newEndpoint creates a client for one host. The password is the token with
+// the v2 API.
+func newEndpoint(host, username, password string) (*endpoint, error) {
+	var proto string
+	if sslFlag {
+		proto = "https"
+	} else {
+		proto = "http"
+	}
+	var u, _ = url.Parse(fmt.Sprintf("%s://%s/", proto, host))
+	config := influx.HTTPConfig{Addr: u.String(), Username: username, Password: password, UserAgent: "sysinfo_influxdb v" + applicationVersion}
+
+	if sslFlag {
+		tlsConfig, err := newTLSConfig(tlsCAFlag, tlsCertFlag, tlsKeyFlag, tlsServerNameFlag, insecureSkipVerifyFlag)
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = tlsConfig
+	}
+
+	var client influx.Client
+	var err error
+	switch influxAPIFlag {
+	case "v1":
+		client, err = newV1Client(config)
+	case "v2":
+		client, err = newInfluxV2Client(config, orgFlag, bucketFlag, password, gzipFlag)
+	default:
+		err = fmt.Errorf("unknown InfluxDB API `%s'", influxAPIFlag)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint{addr: u.String(), client: client}, nil
+}
+
+// influxEnabled reports whether an InfluxDB output is configured: a database
+// for the v1 API, or a bucket for the v2 API.
+func influxEnabled() bool {
+	if influxAPIFlag == "v2" {
+		return bucketFlag != ""
+	}
+	return databaseFlag != ""
+}
+
+func newV1Client(config influx.HTTPConfig) (influx.Client, error) {
+	client, err := influx.NewHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriteClient(client, config, gzipFlag)
+}
+
+/**
+ * Interactions with InfluxDB
+ */
+
+func buildOutputList(client influx.Client) []output {
+	format, err := getFormatter(formatFlag)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var outputs []output
+	if client != nil {
+		var onConnect func(influx.Client) error
+		if createDatabaseFlag {
+			onConnect = func(client influx.Client) error {
+				return provisionDatabase(client, databaseFlag, retentionPolicyFlag, rpDurationFlag, rpReplicationFlag, rpDefaultFlag)
 			}
-			config.Password = strings.Split(string(data), "\n")[0]
-		} else {
-			config.Password = passwordFlag
 		}
+		o, err := newInfluxOutput(client, bufferPolicyFlag, bufferSizeFlag, onConnect)
+		if err != nil {
+			log.Panic(err)
+		}
+		outputs = append(outputs, o)
+	}
 
-		var err error
-		client, err = influx.NewHTTPClient(config)
+	if fileFlag != "" {
+		precision, err := lineProtocolPrecision(filePrecisionFlag)
 		if err != nil {
 			log.Panic(err)
 		}
+		o, err := newFileOutput(fileFlag, format, precision, fileRotateSizeFlag, fileRotateIntervalFlag, fileCompressFlag, fileMaxFilesFlag)
+		if err != nil {
+			log.WithError(err).Panic("Unable to open output file\n")
+		}
+		outputs = append(outputs, o)
+	}
+
+	if graphiteFlag != "" {
+		outputs = append(outputs, newGraphiteOutput(graphiteFlag, graphitePrefixFlag, graphiteTemplateFlag, graphiteReplaceFlag))
+	}
 
-		ti, s, err := client.Ping(time.Second)
+	if openTSDBFlag != "" {
+		o, err := newOpenTSDBOutput(openTSDBFlag, openTSDBPrefixFlag, openTSDBBatchSizeFlag)
 		if err != nil {
 			log.Panic(err)
 		}
+		outputs = append(outputs, o)
+	}
 
-		log.Infof("Connected to: %s; ping: %d; version: %s", u, ti, s)
+	if statsDFlag != "" {
+		o, err := newStatsDOutput(statsDFlag, statsDPrefixFlag, statsDTagsFlag)
+		if err != nil {
+			log.Panic(err)
+		}
+		outputs = append(outputs, o)
 	}
-	return client
+
+	if mqttFlag != "" {
+		tlsConfig, err := newTLSConfig(mqttTLSCAFlag, mqttTLSCertFlag, mqttTLSKeyFlag, "", false)
+		if err != nil {
+			log.Panic(err)
+		}
+		clientID := mqttClientIDFlag
+		if clientID == "" {
+			clientID = "influxdb_reporter-" + getFqdn()
+		}
+		o, err := newMQTTOutput(mqttFlag, mqttTopicFlag, mqttQoSFlag, mqttRetainFlag, mqttFormatFlag,
+			clientID, mqttUsernameFlag, mqttPasswordFlag, tlsConfig)
+		if err != nil {
+			log.Panic(err)
+		}
+		outputs = append(outputs, o)
+	}
+
+	if otlpFlag != "" {
+		o, err := newOTLPOutput(otlpFlag, otlpHeadersFlag)
+		if err != nil {
+			log.Panic(err)
+		}
+		outputs = append(outputs, o)
+	}
+
+	if prometheusListenFlag != "" {
+		o, err := newPrometheusOutput(prometheusListenFlag)
+		if err != nil {
+			log.WithError(err).Panic("Unable to listen for Prometheus\n")
+		}
+		outputs = append(outputs, o)
+	}
+
+	// Without any other output, display data
+	if len(outputs) == 0 || verboseFlag {
+		outputs = append([]output{&stdoutOutput{format}}, outputs...)
+	}
+	return outputs
 }
 
-/**
- * Interactions with InfluxDB
- */
+func closeOutputs(outputs []output) {
+	for _, o := range outputs {
+		if err := o.Close(); err != nil {
+			log.WithError(err).Errorf("Error while closing %s.", o)
+		}
+	}
+}
 
-func buildCollectionList() []collectionFunc {
-	var collectList []collectionFunc
+func buildCollectionList() ([]collector, error) {
+	var collectList []collector
 	for _, c := range strings.Split(collectFlag, ",") {
-		switch strings.Trim(c, " ") {
-		case "cpu":
-			collectList = append(collectList, cpu)
-		case "cpus":
-			collectList = append(collectList, cpus)
-		case "mem":
-			collectList = append(collectList, mem)
-		case "swap":
-			collectList = append(collectList, swap)
-		case "uptime":
-			collectList = append(collectList, uptime)
-		case "load":
-			collectList = append(collectList, load)
-		case "network":
-			collectList = append(collectList, network)
-		case "disks":
-			collectList = append(collectList, disks)
-		case "mounts":
-			collectList = append(collectList, mounts)
-		default:
-			log.Panicf("Unknown collect option `%s'\n", c)
-			return nil
-		}
-	}
-	return collectList
+		cl, ok := findCollector(strings.Trim(c, " "))
+		if !ok {
+			return nil, fmt.Errorf("unknown collect option `%s'", c)
+		}
+		collectList = append(collectList, cl)
+	}
+	return collectList, nil
+}
+
+// checkFlags validates the flags that don't need any connection and sets
+// the derived settings.
+func checkFlags() error {
+	var err error
+	if writePrecision, err = lineProtocolPrecision(precisionFlag); err != nil {
+		return err
+	}
+
+	switch writeConsistencyFlag {
+	case "", "any", "one", "quorum", "all":
+	default:
+		return fmt.Errorf("unknown write consistency `%s'", writeConsistencyFlag)
+	}
+
+	if createDatabaseFlag && influxAPIFlag == "v2" {
+		return errProvisionV2
+	}
+
+	if _, err := getFormatter(formatFlag); err != nil {
+		return err
+	}
+
+	if recordFlag != "" && replayFlag != "" {
+		return fmt.Errorf("-record and -replay can't be used together")
+	}
+
+	if !sslFlag && (tlsCAFlag != "" || tlsCertFlag != "" || tlsKeyFlag != "" || tlsServerNameFlag != "" || insecureSkipVerifyFlag) {
+		return fmt.Errorf("-tls-ca, -tls-cert, -tls-key, -tls-server-name and -insecure-skip-verify require -ssl")
+	}
+
+	if rulesFlag != "" {
+		if _, err := loadRules(rulesFlag); err != nil {
+			return err
+		}
+	}
+
+	if aggregateWindowFlag > 0 {
+		if _, err := newAggregator(aggregateWindowFlag, aggregateFunctionsFlag, aggregatePercentilesFlag, aggregatePercentileFieldsFlag, aggregateSuffixFlag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findCollector(name string) (collector, bool) {
+	for _, cl := range collectors {
+		if cl.name == name {
+			return cl, true
+		}
+	}
+	return collector{}, false
+}
+
+// isCounter reports whether a field of a measurement is a cumulative
+// counter.
+func isCounter(measurement, name string) bool {
+	cl, ok := findCollector(measurement)
+	if !ok {
+		return false
+	}
+	for _, f := range cl.fields {
+		if f.Name == name {
+			return f.Counter
+		}
+	}
+	return false
 }
 
 /**
@@ -268,13 +828,16 @@ func buildCollectionList() []collectionFunc {
  */
 
 func send(client influx.Client, series []*influx.Point) error {
-	c := influx.BatchPointsConfig{Database: databaseFlag, RetentionPolicy: retentionPolicyFlag}
-	w, _ := influx.NewBatchPoints(c)
+	w := &batchPoints{
+		database:         databaseFlag,
+		retentionPolicy:  retentionPolicyFlag,
+		precision:        writePrecision,
+		writeConsistency: writeConsistencyFlag,
+	}
 
 	w.AddPoints(series)
 
-	err := client.Write(w)
-	return err
+	return client.Write(w)
 }
 
 var (
@@ -282,11 +845,7 @@ var (
 	lastSeries = make(map[string]map[string]interface{})
 )
 
-func diffFromLast(point *influx.Point) *influx.Point {
-	mutex.Lock()
-	defer mutex.Unlock()
-	notComplete := false
-
+func seriesKey(point *influx.Point) string {
 	var keys []string
 
 	for k := range point.Tags() {
@@ -298,6 +857,27 @@ func diffFromLast(point *influx.Point) *influx.Point {
 	for _, k := range keys {
 		key += k + ":" + point.Tags()[k] + "|"
 	}
+	return key
+}
+
+// rawFields returns the field values of point as collected: Fields()
+// returns the difference to the previous collection once the point went
+// through diffFromLast, while the point itself is written with the raw
+// values.
+func rawFields(point *influx.Point) (map[string]interface{}, error) {
+	parsed, err := models.ParsePointsString(point.String())
+	if err != nil {
+		return nil, err
+	}
+	return parsed[0].Fields()
+}
+
+func diffFromLast(point *influx.Point) *influx.Point {
+	mutex.Lock()
+	defer mutex.Unlock()
+	notComplete := false
+
+	key := seriesKey(point)
 
 	if _, ok := lastSeries[key]; !ok {
 		lastSeries[key] = make(map[string]interface{})
@@ -362,14 +942,7 @@ func cpu(ch chan collectionResult) {
 		map[string]string{
 			"cpuid": "all",
 		},
-		map[string]interface{}{
-			"user":  cpu.User,
-			"nice":  cpu.Nice,
-			"sys":   cpu.Sys,
-			"idle":  cpu.Idle,
-			"wait":  cpu.Wait,
-			"total": cpu.Total(),
-		},
+		colFields(cpuCols, cpu.User, cpu.Nice, cpu.Sys, cpu.Idle, cpu.Wait, cpu.Total()),
 	)
 
 	ch <- collectionResult{[]*influx.Point{diffFromLast(series)}, nil}
@@ -390,14 +963,7 @@ func cpus(ch chan collectionResult) {
 			map[string]string{
 				"cpuid": fmt.Sprint(i),
 			},
-			map[string]interface{}{
-				"user":  cpu.User,
-				"nice":  cpu.Nice,
-				"sys":   cpu.Sys,
-				"idle":  cpu.Idle,
-				"wait":  cpu.Wait,
-				"total": cpu.Total(),
-			},
+			colFields(cpuCols, cpu.User, cpu.Nice, cpu.Sys, cpu.Idle, cpu.Wait, cpu.Total()),
 		)
 
 		if serie = diffFromLast(serie); serie != nil {
@@ -412,18 +978,13 @@ func mem(ch chan collectionResult) {
 	mem := sigar.Mem{}
 	if err := mem.Get(); err != nil {
 		ch <- collectionResult{nil, err}
+		return
 	}
 
 	series := newPoint(
 		"mem",
 		map[string]string{},
-		map[string]interface{}{
-			"free":       mem.Free,
-			"used":       mem.Used,
-			"actualfree": mem.ActualFree,
-			"actualused": mem.ActualUsed,
-			"total":      mem.Total,
-		},
+		colFields(memCols, mem.Free, mem.Used, mem.ActualFree, mem.ActualUsed, mem.Total),
 	)
 
 	ch <- collectionResult{[]*influx.Point{series}, nil}
@@ -439,11 +1000,7 @@ func swap(ch chan collectionResult) {
 	series := newPoint(
 		"swap",
 		map[string]string{},
-		map[string]interface{}{
-			"free":  swap.Free,
-			"used":  swap.Used,
-			"total": swap.Total,
-		},
+		colFields(swapCols, swap.Free, swap.Used, swap.Total),
 	)
 
 	ch <- collectionResult{[]*influx.Point{series}, nil}
@@ -459,9 +1016,7 @@ func uptime(ch chan collectionResult) {
 	serie := newPoint(
 		"uptime",
 		map[string]string{},
-		map[string]interface{}{
-			"length": uptime.Length,
-		},
+		colFields(uptimeCols, uptime.Length),
 	)
 
 	ch <- collectionResult{[]*influx.Point{serie}, nil}
@@ -477,18 +1032,14 @@ func load(ch chan collectionResult) {
 	series := newPoint(
 		"load",
 		map[string]string{},
-		map[string]interface{}{
-			"one":     load.One,
-			"five":    load.Five,
-			"fifteen": load.Fifteen,
-		},
+		colFields(loadCols, load.One, load.Five, load.Fifteen),
 	)
 
 	ch <- collectionResult{[]*influx.Point{series}, nil}
 }
 
 func network(ch chan collectionResult) {
-	fi, err := os.Open("/proc/net/dev")
+	fi, err := source.open("net/dev")
 	if err != nil {
 		ch <- collectionResult{nil, err}
 		return
@@ -497,12 +1048,6 @@ func network(ch chan collectionResult) {
 
 	var series []*influx.Point
 
-	cols := []string{"recv_bytes", "recv_packets", "recv_errs", "recv_drop",
-		"recv_fifo", "recv_frame", "recv_compressed",
-		"recv_multicast", "trans_bytes", "trans_packets",
-		"trans_errs", "trans_drop", "trans_fifo",
-		"trans_colls", "trans_carrier", "trans_compressed"}
-
 	// Search interface
 	skip := 2
 	scanner := bufio.NewScanner(fi)
@@ -522,7 +1067,7 @@ func network(ch chan collectionResult) {
 
 		tmpf := strings.Fields(tmp[1])
 		fields := map[string]interface{}{}
-		for i, vc := range cols {
+		for i, vc := range networkCols {
 			if vt, err := strconv.Atoi(tmpf[i]); err == nil {
 				fields[vc] = vt
 			} else {
@@ -547,7 +1092,7 @@ func network(ch chan collectionResult) {
 }
 
 func disks(ch chan collectionResult) {
-	fi, err := os.Open("/proc/diskstats")
+	fi, err := source.open("diskstats")
 	if err != nil {
 		ch <- collectionResult{nil, err}
 		return
@@ -556,10 +1101,6 @@ func disks(ch chan collectionResult) {
 
 	var series []*influx.Point
 
-	cols := []string{"read_ios", "read_merges", "read_sectors", "read_ticks",
-		"write_ios", "write_merges", "write_sectors", "write_ticks",
-		"in_flight", "io_ticks", "time_in_queue"}
-
 	// Search device
 	scanner := bufio.NewScanner(fi)
 	for scanner.Scan() {
@@ -570,7 +1111,7 @@ func disks(ch chan collectionResult) {
 		}
 
 		fields := map[string]interface{}{}
-		for i, vc := range cols {
+		for i, vc := range diskCols {
 			if vt, err := strconv.Atoi(tmp[3+i]); err == nil {
 				fields[vc] = vt
 			} else {
@@ -595,7 +1136,7 @@ func disks(ch chan collectionResult) {
 }
 
 func mounts(ch chan collectionResult) {
-	fi, err := os.Open("/proc/mounts")
+	fi, err := source.open("mounts")
 	if err != nil {
 		ch <- collectionResult{nil, err}
 		return
@@ -616,9 +1157,7 @@ func mounts(ch chan collectionResult) {
 
 		// Some hack needed to remove "none" virtual mountpoints
 		if (stringInSlice(tmp[2], sysfs) == false) && (tmp[0] != "none") {
-			fs := syscall.Statfs_t{}
-
-			err := syscall.Statfs(tmp[1], &fs)
+			fs, err := source.statfs(tmp[1])
 			if err != nil {
 				ch <- collectionResult{nil, err}
 				return
@@ -630,10 +1169,7 @@ func mounts(ch chan collectionResult) {
 					"disk":       tmp[0],
 					"mountpoint": tmp[1],
 				},
-				map[string]interface{}{
-					"free":  fs.Bfree * uint64(fs.Bsize),
-					"total": fs.Blocks * uint64(fs.Bsize),
-				},
+				colFields(mountCols, fs.Bfree*uint64(fs.Bsize), fs.Blocks*uint64(fs.Bsize)),
 			)
 
 			if serie = diffFromLast(serie); serie != nil {
@@ -645,6 +1181,16 @@ func mounts(ch chan collectionResult) {
 	ch <- collectionResult{series, nil}
 }
 
+// colFields maps the field names of a collector to their values, given in
+// the same order.
+func colFields(cols []string, values ...interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(cols))
+	for i, name := range cols {
+		fields[name] = values[i]
+	}
+	return fields
+}
+
 func getFqdn() string {
 	// Note: We use exec here instead of os.Hostname() because we
 	// want the FQDN, and this is the easiest way to get it.
@@ -671,13 +1217,13 @@ func stringInSlice(a string, list []string) bool {
 
 func newPoint(name string, tags map[string]string, fields map[string]interface{}) *influx.Point {
 
-	tags["fqdn"] = getFqdn()
+	tags["fqdn"] = source.fqdn()
 
 	point, err := influx.NewPoint(
 		name,
 		tags,
 		fields,
-		time.Now(),
+		source.now(),
 	)
 
 	if err != nil {
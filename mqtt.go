@@ -0,0 +1,363 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const mqttTimeout = 10 * time.Second
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttPubrec     = 5
+	mqttPubrel     = 6
+	mqttPubcomp    = 7
+	mqttDisconnect = 14
+)
+
+var mqttTopicVariable = regexp.MustCompile(`{[^}]*}`)
+
+// mqttOutput publishes every point as one MQTT message, using a minimal
+// MQTT 3.1.1 client. The topic template may refer to `{measurement}` and to
+// tags, e.g. `metrics/{fqdn}/{measurement}`. The connection is established
+// lazily and re-established on the next write after an error.
+type mqttOutput struct {
+	url       *url.URL
+	topic     string
+	qos       byte
+	retain    bool
+	json      bool
+	clientID  string
+	username  string
+	password  string
+	tlsConfig *tls.Config
+
+	conn     net.Conn
+	reader   *bufio.Reader
+	packetID uint16
+}
+
+func newMQTTOutput(broker, topic string, qos int, retain bool, format, clientID, username, password string, tlsConfig *tls.Config) (*mqttOutput, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return nil, fmt.Errorf("unsupported MQTT scheme `%s', use tcp or ssl", u.Scheme)
+	}
+
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d", qos)
+	}
+	if format != "line" && format != "json" {
+		return nil, fmt.Errorf("unsupported MQTT format `%s', use line or json", format)
+	}
+
+	return &mqttOutput{
+		url:       u,
+		topic:     topic,
+		qos:       byte(qos),
+		retain:    retain,
+		json:      format == "json",
+		clientID:  clientID,
+		username:  username,
+		password:  password,
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+func (o *mqttOutput) String() string {
+	return "mqtt"
+}
+
+func (o *mqttOutput) Write(points []*influx.Point) error {
+	for _, p := range points {
+		payload, err := o.payload(p)
+		if err != nil {
+			return err
+		}
+
+		topic := o.expandTopic(p)
+		if err := o.publish(topic, payload); err != nil {
+			// Retry once on a fresh connection
+			log.WithError(err).Warn("MQTT connection lost, reconnecting.")
+			o.disconnect()
+			if err := o.publish(topic, payload); err != nil {
+				o.disconnect()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *mqttOutput) Close() error {
+	if o.conn != nil {
+		o.conn.Write([]byte{mqttDisconnect << 4, 0})
+	}
+	o.disconnect()
+	return nil
+}
+
+func (o *mqttOutput) payload(p *influx.Point) ([]byte, error) {
+	if o.json {
+		jp, err := newJSONPoint(p, "n")
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(jp)
+	}
+	return []byte(p.String()), nil
+}
+
+// expandTopic replaces the variables of the topic template. Characters with
+// a meaning in MQTT topics are replaced in the values.
+func (o *mqttOutput) expandTopic(p *influx.Point) string {
+	tags := p.Tags()
+	return mqttTopicVariable.ReplaceAllStringFunc(o.topic, func(v string) string {
+		name := v[1 : len(v)-1]
+		value := tags[name]
+		if name == "measurement" {
+			value = p.Name()
+		}
+		return strings.Map(func(r rune) rune {
+			switch r {
+			case '/', '+', '#':
+				return '_'
+			}
+			return r
+		}, value)
+	})
+}
+
+func (o *mqttOutput) connect() error {
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+
+	var conn net.Conn
+	var err error
+	switch o.url.Scheme {
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", o.url.Host, o.tlsConfig)
+	default:
+		conn, err = dialer.Dial("tcp", o.url.Host)
+	}
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writeMQTTString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+
+	flags := byte(0x02) // clean session
+	if o.username != "" {
+		flags |= 0x80
+		if o.password != "" {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	binary.Write(&body, binary.BigEndian, uint16(0)) // no keep alive
+
+	writeMQTTString(&body, o.clientID)
+	if o.username != "" {
+		writeMQTTString(&body, o.username)
+		if o.password != "" {
+			writeMQTTString(&body, o.password)
+		}
+	}
+
+	o.conn = conn
+	o.reader = bufio.NewReader(conn)
+
+	if err := o.writePacket(mqttConnect<<4, body.Bytes()); err != nil {
+		o.disconnect()
+		return err
+	}
+
+	packetType, ack, err := o.readPacket()
+	if err != nil {
+		o.disconnect()
+		return err
+	}
+	if packetType != mqttConnack || len(ack) != 2 {
+		o.disconnect()
+		return errors.New("unexpected response to MQTT connect")
+	}
+	if ack[1] != 0 {
+		o.disconnect()
+		return fmt.Errorf("MQTT connection refused with return code %d", ack[1])
+	}
+
+	log.Infof("Connected to MQTT broker %s", o.url.Host)
+	return nil
+}
+
+func (o *mqttOutput) disconnect() {
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+		o.reader = nil
+	}
+}
+
+// publish sends one message and waits for its acknowledgement according to
+// the QoS level.
+func (o *mqttOutput) publish(topic string, payload []byte) error {
+	if o.conn == nil {
+		if err := o.connect(); err != nil {
+			return err
+		}
+	}
+
+	header := byte(mqttPublish<<4) | o.qos<<1
+	if o.retain {
+		header |= 0x01
+	}
+
+	var body bytes.Buffer
+	writeMQTTString(&body, topic)
+	var id uint16
+	if o.qos > 0 {
+		o.packetID++
+		if o.packetID == 0 {
+			o.packetID++
+		}
+		id = o.packetID
+		binary.Write(&body, binary.BigEndian, id)
+	}
+	body.Write(payload)
+
+	if err := o.writePacket(header, body.Bytes()); err != nil {
+		return err
+	}
+
+	switch o.qos {
+	case 1:
+		return o.awaitAck(mqttPuback, id)
+	case 2:
+		if err := o.awaitAck(mqttPubrec, id); err != nil {
+			return err
+		}
+		var rel bytes.Buffer
+		binary.Write(&rel, binary.BigEndian, id)
+		if err := o.writePacket(mqttPubrel<<4|0x02, rel.Bytes()); err != nil {
+			return err
+		}
+		return o.awaitAck(mqttPubcomp, id)
+	}
+	return nil
+}
+
+func (o *mqttOutput) awaitAck(packetType byte, id uint16) error {
+	for {
+		t, body, err := o.readPacket()
+		if err != nil {
+			return err
+		}
+		if t == packetType && len(body) >= 2 && binary.BigEndian.Uint16(body) == id {
+			return nil
+		}
+	}
+}
+
+func (o *mqttOutput) writePacket(header byte, body []byte) error {
+	var b bytes.Buffer
+	b.WriteByte(header)
+
+	// Remaining length, 7 bits per byte
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b.WriteByte(digit)
+		if n == 0 {
+			break
+		}
+	}
+	b.Write(body)
+
+	o.conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+	_, err := o.conn.Write(b.Bytes())
+	return err
+}
+
+func (o *mqttOutput) readPacket() (byte, []byte, error) {
+	o.conn.SetReadDeadline(time.Now().Add(mqttTimeout))
+
+	header, err := o.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := o.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(o.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
+
+func writeMQTTString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
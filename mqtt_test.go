@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type testMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// runTestBroker accepts MQTT connections and acknowledges every message.
+// With once set, each connection is closed after its first message.
+func runTestBroker(t *testing.T, listener net.Listener, once bool, messages chan testMessage) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			o := &mqttOutput{conn: conn, reader: bufio.NewReader(conn)}
+
+			if packetType, _, err := o.readPacket(); err != nil || packetType != mqttConnect {
+				t.Error("Expected connect packet", err)
+				return
+			}
+			o.writePacket(mqttConnack<<4, []byte{0, 0})
+
+			for {
+				header, err := o.reader.Peek(1)
+				if err != nil {
+					return
+				}
+				qos := header[0] >> 1 & 0x03
+				retain := header[0]&0x01 == 1
+
+				packetType, body, err := o.readPacket()
+				if err == io.EOF || packetType == mqttDisconnect {
+					return
+				} else if err != nil || packetType != mqttPublish {
+					t.Error("Expected publish packet", err)
+					return
+				}
+
+				n := int(binary.BigEndian.Uint16(body))
+				topic := string(body[2 : 2+n])
+				body = body[2+n:]
+				if qos > 0 {
+					id := body[:2]
+					body = body[2:]
+					if qos == 1 {
+						o.writePacket(mqttPuback<<4, id)
+					} else {
+						o.writePacket(mqttPubrec<<4, id)
+						o.readPacket()
+						o.writePacket(mqttPubcomp<<4, id)
+					}
+				}
+
+				messages <- testMessage{topic, string(body), retain}
+				if once {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	messages := make(chan testMessage, 10)
+	go runTestBroker(t, listener, false, messages)
+
+	point, _ := influxClient.NewPoint(
+		"mounts",
+		map[string]string{"fqdn": "koala", "mountpoint": "/home"},
+		map[string]interface{}{"free": 42},
+		time.Unix(1, 0),
+	)
+
+	for qos := 0; qos <= 2; qos++ {
+		o, err := newMQTTOutput("tcp://"+listener.Addr().String(), "metrics/{fqdn}/{measurement}/{mountpoint}",
+			qos, true, "json", "test", "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := o.Write([]*influxClient.Point{point}); err != nil {
+			t.Fatal(err)
+		}
+		o.Close()
+
+		m := <-messages
+		if m.topic != "metrics/koala/mounts/_home" || !m.retain {
+			t.Error("Unexpected message", m)
+		}
+		var jp map[string]interface{}
+		if err := json.Unmarshal([]byte(m.payload), &jp); err != nil || jp["measurement"] != "mounts" {
+			t.Error("Unexpected payload", m.payload, err)
+		}
+	}
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	messages := make(chan testMessage, 10)
+	go runTestBroker(t, listener, true, messages)
+
+	o, err := newMQTTOutput("tcp://"+listener.Addr().String(), "metrics/{measurement}", 1, false, "line", "test", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := o.Write([]*influxClient.Point{newTestLoadPoint()}); err != nil {
+			t.Fatal(err)
+		}
+		if m := <-messages; m.topic != "metrics/load" {
+			t.Error("Unexpected topic", m.topic)
+		}
+	}
+}
+
+func TestMQTTOptions(t *testing.T) {
+	if _, err := newMQTTOutput("http://localhost", "", 0, false, "line", "", "", "", nil); err == nil {
+		t.Error("Unsupported scheme should be rejected")
+	}
+	if _, err := newMQTTOutput("tcp://localhost", "", 3, false, "line", "", "", "", nil); err == nil {
+		t.Error("Invalid QoS should be rejected")
+	}
+}
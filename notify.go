@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notificationTimeout bounds each webhook request and command run.
+var notificationTimeout = 10 * time.Second
+
+// notificationRetryDelay is the delay before the first retry of a failed
+// notification; it doubles on each retry.
+var notificationRetryDelay = time.Second
+
+// notification is the JSON payload posted to webhooks.
+type notification struct {
+	alertEvent
+	Host string `json:"host"`
+}
+
+// notifier sends alert state changes to webhooks and runs a command for
+// them, in the background so that failing receivers don't delay
+// collections. Firing alerts are only notified again after the renotify
+// interval.
+type notifier struct {
+	webhooks   []string
+	command    string
+	retries    int
+	renotify   time.Duration
+	httpClient *http.Client
+
+	notified map[string]time.Time
+	queue    chan notification
+}
+
+func newNotifier(webhooks, command string, retries int, renotify time.Duration) *notifier {
+	n := &notifier{
+		command:    command,
+		retries:    retries,
+		renotify:   renotify,
+		httpClient: &http.Client{Timeout: notificationTimeout},
+		notified:   make(map[string]time.Time),
+		queue:      make(chan notification, 100),
+	}
+	for _, url := range strings.Split(webhooks, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			n.webhooks = append(n.webhooks, url)
+		}
+	}
+
+	go n.run()
+	return n
+}
+
+// notify queues the state changes of a collection, and the alerts still
+// firing that are due for a new notification.
+func (n *notifier) notify(events, firing []alertEvent, now time.Time) {
+	for _, ev := range events {
+		if ev.State == "resolved" {
+			delete(n.notified, ev.key)
+		} else {
+			n.notified[ev.key] = now
+		}
+		n.enqueue(ev)
+	}
+
+	if n.renotify <= 0 {
+		return
+	}
+	for _, ev := range firing {
+		if last, ok := n.notified[ev.key]; ok && now.Sub(last) >= n.renotify {
+			n.notified[ev.key] = now
+			n.enqueue(ev)
+		}
+	}
+}
+
+func (n *notifier) enqueue(ev alertEvent) {
+	select {
+	case n.queue <- notification{ev, ev.Tags["fqdn"]}:
+	default:
+		log.Errorf("Too many pending notifications, dropping alert %s %s.", ev.Rule, ev.State)
+	}
+}
+
+func (n *notifier) run() {
+	for notif := range n.queue {
+		for _, url := range n.webhooks {
+			n.retry("webhook "+url, notif, func() error { return n.post(url, notif) })
+		}
+		if n.command != "" {
+			n.retry("command", notif, func() error { return n.execute(notif) })
+		}
+	}
+}
+
+// retry calls send until it succeeds, at most 1+retries times.
+func (n *notifier) retry(receiver string, notif notification, send func() error) {
+	delay := notificationRetryDelay
+	for i := 0; ; i++ {
+		err := send()
+		if err == nil {
+			return
+		}
+		if i >= n.retries {
+			log.WithError(err).Errorf("Cannot notify %s of alert %s %s.", receiver, notif.Rule, notif.State)
+			return
+		}
+		log.WithError(err).Warnf("Cannot notify %s of alert %s, retrying in %s.", receiver, notif.Rule, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *notifier) post(url string, notif notification) error {
+	body, err := json.Marshal(notif)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sysinfo_influxdb v"+applicationVersion)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// execute runs the command with sh, with the alert in ALERT_* environment
+// variables; ALERT_TAGS holds all tags and ALERT_TAG_<KEY> each of them.
+func (n *notifier) execute(notif notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.command)
+	cmd.Env = append(os.Environ(), alertEnv(notif)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func alertEnv(notif notification) []string {
+	env := []string{
+		"ALERT_RULE=" + notif.Rule,
+		"ALERT_STATE=" + notif.State,
+		"ALERT_EXPRESSION=" + notif.Expression,
+		"ALERT_VALUE=" + strconv.FormatFloat(notif.Value, 'g', -1, 64),
+		"ALERT_HOST=" + notif.Host,
+		"ALERT_SINCE=" + notif.Since.Format(time.RFC3339),
+		"ALERT_TIME=" + notif.Time.Format(time.RFC3339),
+	}
+
+	var tags []string
+	for k, v := range notif.Tags {
+		tags = append(tags, k+"="+v)
+		env = append(env, "ALERT_TAG_"+strings.ToUpper(envNameReplacer.Replace(k))+"="+v)
+	}
+	sort.Strings(tags)
+	return append(env, "ALERT_TAGS="+strings.Join(tags, ","))
+}
+
+var envNameReplacer = strings.NewReplacer("-", "_", ".", "_", " ", "_")
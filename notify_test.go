@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAlert(state string) alertEvent {
+	return alertEvent{
+		Rule:       "disk full",
+		State:      state,
+		Expression: "mounts.free/mounts.total < 0.05",
+		Value:      0.01,
+		Tags:       map[string]string{"fqdn": "host1", "mountpoint": "/var"},
+		key:        "disk full#mounts",
+	}
+}
+
+func TestNotifierWebhook(t *testing.T) {
+	defer func(d time.Duration) { notificationRetryDelay = d }(notificationRetryDelay)
+	notificationRetryDelay = time.Millisecond
+
+	received := make(chan notification, 10)
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var notif notification
+		if err := json.NewDecoder(r.Body).Decode(&notif); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		received <- notif
+	}))
+	defer server.Close()
+
+	n := newNotifier(server.URL, "", 2, time.Minute)
+	now := time.Now()
+	firing := []alertEvent{newTestAlert("firing")}
+
+	n.notify(firing, firing, now)
+	// Still firing, not due yet
+	n.notify(nil, firing, now.Add(30*time.Second))
+	n.notify(nil, firing, now.Add(time.Minute))
+	n.notify([]alertEvent{newTestAlert("resolved")}, nil, now.Add(90*time.Second))
+
+	for _, expected := range []string{"firing", "firing", "resolved"} {
+		select {
+		case notif := <-received:
+			if notif.Rule != "disk full" || notif.State != expected || notif.Host != "host1" || notif.Tags["mountpoint"] != "/var" {
+				t.Errorf("Expected %s notification, got %+v", expected, notif)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected", expected, "notification")
+		}
+	}
+
+	select {
+	case notif := <-received:
+		t.Error("Unexpected notification", notif)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifierCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "alerts")
+
+	n := newNotifier("", `echo "$ALERT_RULE|$ALERT_STATE|$ALERT_VALUE|$ALERT_HOST|$ALERT_TAG_MOUNTPOINT|$ALERT_TAGS" >> `+out, 0, 0)
+	n.notify([]alertEvent{newTestAlert("firing")}, nil, time.Now())
+
+	expected := "disk full|firing|0.01|host1|/var|fqdn=host1,mountpoint=/var\n"
+	var actual []byte
+	for i := 0; i < 100 && string(actual) != expected; i++ {
+		time.Sleep(20 * time.Millisecond)
+		actual, _ = ioutil.ReadFile(out)
+	}
+	if string(actual) != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+}
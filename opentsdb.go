@@ -0,0 +1,232 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const openTSDBTimeout = 5 * time.Second
+
+// openTSDBOutput sends points to OpenTSDB, either with the telnet `put`
+// protocol (tcp://host:port) or as JSON batches to /api/put
+// (http://host:port). Each field becomes a metric named
+// [prefix.]<measurement>.<field>, tagged with the point's tags. Values are
+// the ones written to InfluxDB: counters are sent as totals, e.g. for the
+// rate option of queries.
+type openTSDBOutput struct {
+	url       *url.URL
+	prefix    string
+	batchSize int
+
+	conn       net.Conn
+	httpClient *http.Client
+}
+
+type openTSDBDataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func newOpenTSDBOutput(addr, prefix string, batchSize int) (*openTSDBOutput, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported OpenTSDB scheme `%s', use tcp, http or https", u.Scheme)
+	}
+
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	return &openTSDBOutput{
+		url:        u,
+		prefix:     prefix,
+		batchSize:  batchSize,
+		httpClient: &http.Client{Timeout: openTSDBTimeout},
+	}, nil
+}
+
+func (o *openTSDBOutput) String() string {
+	return "opentsdb"
+}
+
+func (o *openTSDBOutput) Write(points []*influx.Point) error {
+	dataPoints, err := o.dataPoints(points)
+	if err != nil {
+		return err
+	}
+
+	if o.url.Scheme == "tcp" {
+		return o.put(dataPoints)
+	}
+
+	for len(dataPoints) > 0 {
+		n := o.batchSize
+		if n > len(dataPoints) {
+			n = len(dataPoints)
+		}
+		if err := o.post(dataPoints[:n]); err != nil {
+			return err
+		}
+		dataPoints = dataPoints[n:]
+	}
+	return nil
+}
+
+func (o *openTSDBOutput) Close() error {
+	o.disconnect()
+	return nil
+}
+
+func (o *openTSDBOutput) dataPoints(points []*influx.Point) ([]openTSDBDataPoint, error) {
+	var dataPoints []openTSDBDataPoint
+	for _, p := range points {
+		fields, err := rawFields(p)
+		if err != nil {
+			return nil, err
+		}
+
+		tags := make(map[string]string)
+		for k, v := range p.Tags() {
+			tags[openTSDBSanitize(k)] = openTSDBSanitize(v)
+		}
+
+		metric := p.Name()
+		if o.prefix != "" {
+			metric = o.prefix + "." + metric
+		}
+
+		for k, v := range fields {
+			value, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			dataPoints = append(dataPoints, openTSDBDataPoint{
+				Metric:    openTSDBSanitize(metric + "." + k),
+				Timestamp: p.Time().Unix(),
+				Value:     value,
+				Tags:      tags,
+			})
+		}
+	}
+	return dataPoints, nil
+}
+
+// put writes data points with the telnet protocol, reconnecting once if the
+// connection was lost.
+func (o *openTSDBOutput) put(dataPoints []openTSDBDataPoint) error {
+	var b bytes.Buffer
+	for _, dp := range dataPoints {
+		var keys []string
+		for k := range dp.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&b, "put %s %d %s", dp.Metric, dp.Timestamp, strconv.FormatFloat(dp.Value, 'f', -1, 64))
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%s", k, dp.Tags[k])
+		}
+		b.WriteByte('\n')
+	}
+
+	err := o.send(b.Bytes())
+	if err != nil {
+		log.WithError(err).Warn("OpenTSDB connection lost, reconnecting.")
+		o.disconnect()
+		err = o.send(b.Bytes())
+	}
+	return err
+}
+
+func (o *openTSDBOutput) send(b []byte) error {
+	if o.conn == nil {
+		conn, err := net.DialTimeout("tcp", o.url.Host, openTSDBTimeout)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+
+	o.conn.SetWriteDeadline(time.Now().Add(openTSDBTimeout))
+	_, err := o.conn.Write(b)
+	return err
+}
+
+func (o *openTSDBOutput) disconnect() {
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+}
+
+// post sends one batch of data points to /api/put.
+func (o *openTSDBOutput) post(dataPoints []openTSDBDataPoint) error {
+	body, err := json.Marshal(dataPoints)
+	if err != nil {
+		return err
+	}
+
+	u := *o.url
+	u.Path = "/api/put"
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sysinfo_influxdb v"+applicationVersion)
+
+	_, err = doWrite(o.httpClient, req)
+	return err
+}
+
+// openTSDBSanitize replaces characters OpenTSDB does not accept in metric
+// names and tags.
+func openTSDBSanitize(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if r == '-' || r == '_' || r == '.' || r == '/' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
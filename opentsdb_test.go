@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLoadPoint() *influxClient.Point {
+	point, _ := influxClient.NewPoint(
+		"load",
+		map[string]string{"fqdn": "koala example"},
+		map[string]interface{}{"one": 0.5, "five": 1.0, "fifteen": 2.0},
+		time.Unix(1500000000, 0),
+	)
+	return point
+}
+
+func TestOpenTSDBHTTP(t *testing.T) {
+	var batches [][]openTSDBDataPoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/put" {
+			t.Error("Unexpected path", r.URL.Path)
+		}
+		var batch []openTSDBDataPoint
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		batches = append(batches, batch)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	o, err := newOpenTSDBOutput(server.URL, "sys", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write([]*influxClient.Point{newTestLoadPoint()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("Expected batches of 2 and 1 data points, got %v", batches)
+	}
+	dp := batches[0][0]
+	if dp.Timestamp != 1500000000 || dp.Tags["fqdn"] != "koala_example" || dp.Metric[:9] != "sys.load." {
+		t.Error("Unexpected data point", dp)
+	}
+}
+
+func TestOpenTSDBCounters(t *testing.T) {
+	newDisk := func(ios int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"disks",
+			map[string]string{"fqdn": "koala", "device": "sdt"},
+			map[string]interface{}{"read_ios": ios},
+			time.Unix(1500000000, 0),
+		)
+		return p
+	}
+	diffFromLast(newDisk(1000))
+	disk := diffFromLast(newDisk(1500))
+
+	o := &openTSDBOutput{}
+	dataPoints, err := o.dataPoints([]*influxClient.Point{disk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataPoints) != 1 || dataPoints[0].Metric != "disks.read_ios" || dataPoints[0].Value != 1500 {
+		t.Error("Expected the counter total, like in line protocol, got", dataPoints)
+	}
+}
+
+func TestOpenTSDBTelnet(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	lines := make(chan string, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	point, _ := influxClient.NewPoint(
+		"load",
+		map[string]string{"fqdn": "koala"},
+		map[string]interface{}{"one": 0.5},
+		time.Unix(1500000000, 0),
+	)
+
+	o, err := newOpenTSDBOutput("tcp://"+listener.Addr().String(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	if err := o.Write([]*influxClient.Point{point}); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-lines; line != "put load.one 1500000000 0.5 fqdn=koala" {
+		t.Error("Unexpected line", line)
+	}
+}
+
+func TestOpenTSDBScheme(t *testing.T) {
+	if _, err := newOpenTSDBOutput("udp://localhost:4242", "", 0); err == nil {
+		t.Error("Unsupported scheme should be rejected")
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cloudfoundry/gosigar"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const otlpTimeout = 10 * time.Second
+
+// otlpAggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpAggregationTemporalityCumulative = 2
+
+// otlpOutput exports points as OpenTelemetry metrics with OTLP/HTTP and the
+// JSON encoding. Each field becomes a metric named <measurement>.<field>.
+// Fields of counter measurements are exported as monotonic cumulative Sums
+// of their raw value, starting at boot time; all other fields as Gauges.
+// The fqdn tag becomes the host.name resource attribute, the remaining tags
+// data point attributes.
+type otlpOutput struct {
+	url        string
+	headers    map[string]string
+	startTime  time.Time
+	httpClient *http.Client
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func newOTLPOutput(endpoint, headers string) (*otlpOutput, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported OTLP scheme `%s', use http or https", u.Scheme)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+
+	o := &otlpOutput{
+		url:        u.String(),
+		headers:    make(map[string]string),
+		startTime:  bootTime(),
+		httpClient: &http.Client{Timeout: otlpTimeout},
+	}
+
+	if headers != "" {
+		for _, h := range strings.Split(headers, ",") {
+			kv := strings.SplitN(h, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid OTLP header `%s', use key=value", h)
+			}
+			o.headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	return o, nil
+}
+
+func (o *otlpOutput) String() string {
+	return "otlp"
+}
+
+func (o *otlpOutput) Write(points []*influx.Point) error {
+	body, err := json.Marshal(o.request(points))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sysinfo_influxdb v"+applicationVersion)
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+
+	_, err = doWrite(o.httpClient, req)
+	return err
+}
+
+func (o *otlpOutput) Close() error {
+	return nil
+}
+
+// request groups the points by host and metric name.
+func (o *otlpOutput) request(points []*influx.Point) otlpRequest {
+	hosts := make(map[string]map[string]*otlpMetric)
+	startTime := strconv.FormatInt(o.startTime.UnixNano(), 10)
+
+	for _, p := range points {
+		// Counters are exported as cumulative sums, not as differences
+		fields, err := rawFields(p)
+		if err != nil {
+			continue
+		}
+
+		tags := p.Tags()
+		host := tags["fqdn"]
+		var attributes []otlpKeyValue
+		for k, v := range tags {
+			if k != "fqdn" {
+				attributes = append(attributes, otlpKeyValue{k, otlpAnyValue{v}})
+			}
+		}
+		sort.Slice(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+
+		if _, ok := hosts[host]; !ok {
+			hosts[host] = make(map[string]*otlpMetric)
+		}
+		metrics := hosts[host]
+
+		for k, v := range fields {
+			value, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+
+			counter := isCounter(p.Name(), k)
+			name := p.Name() + "." + k
+			dp := otlpDataPoint{
+				Attributes:   attributes,
+				TimeUnixNano: strconv.FormatInt(p.UnixNano(), 10),
+				AsDouble:     value,
+			}
+
+			m, ok := metrics[name]
+			if !ok {
+				m = &otlpMetric{Name: name}
+				if counter {
+					m.Sum = &otlpSum{AggregationTemporality: otlpAggregationTemporalityCumulative, IsMonotonic: true}
+				} else {
+					m.Gauge = &otlpGauge{}
+				}
+				metrics[name] = m
+			}
+
+			if counter {
+				dp.StartTimeUnixNano = startTime
+				m.Sum.DataPoints = append(m.Sum.DataPoints, dp)
+			} else {
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+			}
+		}
+	}
+
+	var req otlpRequest
+	for host, metrics := range hosts {
+		var rm otlpResourceMetrics
+		rm.Resource.Attributes = []otlpKeyValue{{"host.name", otlpAnyValue{host}}}
+
+		var sm otlpScopeMetrics
+		sm.Scope.Name = "influxdb_reporter"
+		sm.Scope.Version = applicationVersion
+		for _, m := range metrics {
+			sm.Metrics = append(sm.Metrics, m)
+		}
+		sort.Slice(sm.Metrics, func(i, j int) bool { return sm.Metrics[i].Name < sm.Metrics[j].Name })
+
+		rm.ScopeMetrics = []otlpScopeMetrics{sm}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+	}
+	return req
+}
+
+// bootTime is when the kernel counters started, or now if the uptime is
+// unknown.
+func bootTime() time.Time {
+	uptime := sigar.Uptime{}
+	if err := uptime.Get(); err != nil {
+		return time.Now()
+	}
+	return time.Now().Add(-time.Duration(uptime.Length * float64(time.Second)))
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPWrite(t *testing.T) {
+	var req otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Error("Unexpected path", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Error("Missing header", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	newDisk := func(ios int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"disks",
+			map[string]string{"fqdn": "koala", "device": "sda"},
+			map[string]interface{}{"read_ios": ios, "in_flight": 2},
+			time.Unix(2, 0),
+		)
+		return p
+	}
+	diffFromLast(newDisk(10))
+	disk := diffFromLast(newDisk(25))
+
+	o, err := newOTLPOutput(server.URL, "Authorization=Bearer secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write([]*influxClient.Point{newTestLoadPoint(), disk}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.ResourceMetrics) != 2 {
+		t.Fatal("Expected one resource per host, got", req.ResourceMetrics)
+	}
+	for _, rm := range req.ResourceMetrics {
+		host := rm.Resource.Attributes[0]
+		if host.Key != "host.name" {
+			t.Error("Unexpected resource attribute", host)
+		}
+		metrics := rm.ScopeMetrics[0].Metrics
+		switch host.Value.StringValue {
+		case "koala":
+			if len(metrics) != 2 || metrics[0].Name != "disks.in_flight" || metrics[0].Gauge == nil || metrics[0].Gauge.DataPoints[0].AsDouble != 2 {
+				t.Fatal("Expected in_flight to be a gauge, got", metrics)
+			}
+			sum := metrics[1].Sum
+			if metrics[1].Name != "disks.read_ios" || sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != 2 {
+				t.Fatal("Expected a cumulative sum, got", metrics[1])
+			}
+			dp := sum.DataPoints[0]
+			if dp.AsDouble != 25 || dp.StartTimeUnixNano == "" || dp.TimeUnixNano != "2000000000" {
+				t.Error("Unexpected data point", dp)
+			}
+			if len(dp.Attributes) != 1 || dp.Attributes[0].Key != "device" {
+				t.Error("Unexpected attributes", dp.Attributes)
+			}
+		case "koala example":
+			if len(metrics) != 3 || metrics[0].Name != "load.fifteen" || metrics[0].Gauge == nil {
+				t.Error("Expected load gauges, got", metrics)
+			}
+		default:
+			t.Error("Unexpected host", host.Value.StringValue)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"os"
+	"strconv"
+)
+
+// output is a destination for the points collected in one cycle.
+type output interface {
+	fmt.Stringer
+
+	// Write hands over the points of one collection cycle.
+	Write(points []*influx.Point) error
+
+	// Close flushes and releases the output.
+	Close() error
+}
+
+// batchPoints implements influx.BatchPoints without validating the precision
+// against time.ParseDuration, which rejects the `n' and `u' precisions of the
+// line protocol.
+type batchPoints struct {
+	points           []*influx.Point
+	database         string
+	precision        string
+	retentionPolicy  string
+	writeConsistency string
+}
+
+func (bp *batchPoints) AddPoint(p *influx.Point) {
+	bp.points = append(bp.points, p)
+}
+
+func (bp *batchPoints) AddPoints(ps []*influx.Point) {
+	bp.points = append(bp.points, ps...)
+}
+
+func (bp *batchPoints) Points() []*influx.Point {
+	return bp.points
+}
+
+func (bp *batchPoints) Precision() string {
+	return bp.precision
+}
+
+func (bp *batchPoints) SetPrecision(p string) error {
+	bp.precision = p
+	return nil
+}
+
+func (bp *batchPoints) Database() string {
+	return bp.database
+}
+
+func (bp *batchPoints) SetDatabase(db string) {
+	bp.database = db
+}
+
+func (bp *batchPoints) WriteConsistency() string {
+	return bp.writeConsistency
+}
+
+func (bp *batchPoints) SetWriteConsistency(wc string) {
+	bp.writeConsistency = wc
+}
+
+func (bp *batchPoints) RetentionPolicy() string {
+	return bp.retentionPolicy
+}
+
+func (bp *batchPoints) SetRetentionPolicy(rp string) {
+	bp.retentionPolicy = rp
+}
+
+// stdoutOutput prints points in the selected format.
+type stdoutOutput struct {
+	format formatter
+}
+
+func (o *stdoutOutput) String() string {
+	return "stdout"
+}
+
+func (o *stdoutOutput) Write(points []*influx.Point) error {
+	return o.format(os.Stdout, points, "n")
+}
+
+func (o *stdoutOutput) Close() error {
+	return nil
+}
+
+// numericValue converts a field value to float64. Unsigned integers are
+// stored as strings by the InfluxDB client, so numeric strings are accepted
+// too.
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendPrecision(t *testing.T) {
+	var query, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	defer func(db, precision, consistency string) {
+		databaseFlag, writePrecision, writeConsistencyFlag = db, precision, consistency
+	}(databaseFlag, writePrecision, writeConsistencyFlag)
+	databaseFlag, writeConsistencyFlag = "test", "quorum"
+
+	client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL})
+	for precision, expected := range map[string]string{
+		"s":  "test_file,tag0=val0 col0=0i 1\n",
+		"ms": "test_file,tag0=val0 col0=0i 1500\n",
+		"u":  "test_file,tag0=val0 col0=0i 1500000\n",
+	} {
+		writePrecision = precision
+		if err := send(client, newTestPoints(t, 1)); err != nil {
+			t.Fatal(err)
+		}
+		if body != expected {
+			t.Errorf("Expected %q, got %q", expected, body)
+		}
+		if query != "consistency=quorum&db=test&precision="+precision+"&rp=" {
+			t.Error("Unexpected query", query)
+		}
+	}
+}
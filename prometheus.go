@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// prometheusOutput keeps the points of the latest cycle and exposes them at
+// /metrics in the Prometheus text format. Each field becomes a metric named
+// <measurement>_<field>, labelled with the point's tags. Counter fields are
+// exposed with their raw value instead of the difference to the previous
+// collection.
+type prometheusOutput struct {
+	listener net.Listener
+
+	mutex  sync.Mutex
+	points []*influx.Point
+}
+
+func newPrometheusOutput(addr string) (*prometheusOutput, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &prometheusOutput{listener: listener}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", o)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.WithError(err).Debug("Prometheus listener stopped.")
+		}
+	}()
+
+	log.Infof("Serving Prometheus metrics on %s/metrics", listener.Addr())
+	return o, nil
+}
+
+func (o *prometheusOutput) String() string {
+	return "prometheus"
+}
+
+func (o *prometheusOutput) Write(points []*influx.Point) error {
+	o.mutex.Lock()
+	o.points = points
+	o.mutex.Unlock()
+	return nil
+}
+
+func (o *prometheusOutput) Close() error {
+	return o.listener.Close()
+}
+
+func (o *prometheusOutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mutex.Lock()
+	points := o.points
+	o.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(formatPrometheus(points))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type prometheusSample struct {
+	labels string
+	value  float64
+}
+
+// formatPrometheus renders points in the Prometheus text exposition format,
+// grouping samples by metric name.
+func formatPrometheus(points []*influx.Point) []byte {
+	metrics := make(map[string][]prometheusSample)
+	types := make(map[string]string)
+
+	for _, p := range points {
+		// Counters are exposed as totals, not as differences
+		fields, err := rawFields(p)
+		if err != nil {
+			log.WithError(err).Error("Cannot read fields.")
+			continue
+		}
+
+		labels := prometheusLabels(p.Tags())
+		for k, v := range fields {
+			value, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			name := prometheusName(p.Name() + "_" + k)
+			metrics[name] = append(metrics[name], prometheusSample{labels, value})
+			types[name] = "gauge"
+			if isCounter(p.Name(), k) {
+				types[name] = "counter"
+			}
+		}
+	}
+
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, types[name])
+		samples := metrics[name]
+		sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+		for _, s := range samples {
+			fmt.Fprintf(&b, "%s%s %s\n", name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	return b.Bytes()
+}
+
+func prometheusLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	var labels []string
+	for k, v := range tags {
+		labels = append(labels, prometheusLabelName(k)+`="`+labelValueEscaper.Replace(v)+`"`)
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// prometheusName replaces characters not allowed in metric names.
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// prometheusLabelName replaces characters not allowed in label names.
+func prometheusLabelName(name string) string {
+	return strings.Replace(prometheusName(name), ":", "_", -1)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatPrometheus(t *testing.T) {
+	load, _ := influxClient.NewPoint(
+		"load",
+		map[string]string{"fqdn": "koala"},
+		map[string]interface{}{"one": 0.5},
+		time.Now(),
+	)
+
+	newNetwork := func(bytes int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"network",
+			map[string]string{"fqdn": "koala", "iface": `e"th0`},
+			map[string]interface{}{"recv_bytes": bytes},
+			time.Now(),
+		)
+		return p
+	}
+	diffFromLast(newNetwork(100))
+	network := diffFromLast(newNetwork(150))
+
+	mount, _ := influxClient.NewPoint(
+		"mounts",
+		map[string]string{"fqdn": "koala"},
+		map[string]interface{}{"free": uint64(42)},
+		time.Now(),
+	)
+
+	out := string(formatPrometheus([]*influxClient.Point{load, mount, network}))
+	expected := `# TYPE load_one gauge
+load_one{fqdn="koala"} 0.5
+# TYPE mounts_free gauge
+mounts_free{fqdn="koala"} 42
+# TYPE network_recv_bytes counter
+network_recv_bytes{fqdn="koala",iface="e\"th0"} 150
+`
+	if out != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	if name := prometheusName("mounts_free-space.total"); name != "mounts_free_space_total" {
+		t.Error("Unexpected metric name", name)
+	}
+	if name := prometheusLabelName("a:b"); strings.Contains(name, ":") {
+		t.Error("Label names may not contain colons:", name)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+var errProvisionV2 = errors.New("database provisioning is not supported with the v2 API, create the bucket beforehand")
+
+// provisionDatabase creates the database and retention policy if they do
+// not exist yet, then checks that points can be written to them.
+func provisionDatabase(client influx.Client, database, retentionPolicy, duration string, replication int, makeDefault bool) error {
+	if err := query(client, "CREATE DATABASE "+quoteIdent(database), ""); err != nil {
+		// Non-admin users cannot create databases, which is fine if the
+		// database is already there
+		exists, showErr := queryContains(client, "SHOW DATABASES", "", database)
+		if showErr != nil || !exists {
+			return fmt.Errorf("cannot create database %s: %v", database, err)
+		}
+		log.WithError(err).Warnf("Cannot create database %s, using the existing one.", database)
+	}
+
+	if retentionPolicy != "" {
+		exists, err := queryContains(client, "SHOW RETENTION POLICIES ON "+quoteIdent(database), database, retentionPolicy)
+		if err != nil {
+			return fmt.Errorf("cannot list retention policies: %v", err)
+		}
+		if !exists {
+			q := fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION %s REPLICATION %d",
+				quoteIdent(retentionPolicy), quoteIdent(database), duration, replication)
+			if makeDefault {
+				q += " DEFAULT"
+			}
+			if err := query(client, q, database); err != nil {
+				return fmt.Errorf("cannot create retention policy %s: %v", retentionPolicy, err)
+			}
+			log.Infof("Created retention policy %s on %s.", retentionPolicy, database)
+		}
+	}
+
+	// An empty write is rejected if the database does not exist or the user
+	// is not allowed to write to it
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: database, RetentionPolicy: retentionPolicy})
+	if err != nil {
+		return err
+	}
+	if err := client.Write(bp); err != nil {
+		return fmt.Errorf("cannot write to %s: %v", database, err)
+	}
+	return nil
+}
+
+func query(client influx.Client, command, database string) error {
+	resp, err := client.Query(influx.NewQuery(command, database, ""))
+	if err != nil {
+		return err
+	}
+	return resp.Error()
+}
+
+// queryContains reports whether the first column of the query result
+// contains name.
+func queryContains(client influx.Client, command, database, name string) (bool, error) {
+	resp, err := client.Query(influx.NewQuery(command, database, ""))
+	if err != nil {
+		return false, err
+	}
+	if resp.Error() != nil {
+		return false, resp.Error()
+	}
+
+	for _, result := range resp.Results {
+		for _, row := range result.Series {
+			for _, values := range row.Values {
+				if len(values) > 0 && values[0] == name {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// quoteIdent quotes an InfluxQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(strings.Replace(name, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestInfluxServer answers queries with the given results, keyed by the
+// query prefix, and records all queries and writes.
+func newTestInfluxServer(results map[string]string, log *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			q := r.FormValue("q")
+			*log = append(*log, q)
+			for prefix, result := range results {
+				if strings.HasPrefix(q, prefix) {
+					fmt.Fprint(w, result)
+					return
+				}
+			}
+			fmt.Fprint(w, `{"results":[{"statement_id":0}]}`)
+		case "/write":
+			*log = append(*log, "write "+r.URL.Query().Get("db")+"."+r.URL.Query().Get("rp"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestProvisionDatabase(t *testing.T) {
+	var queries []string
+	server := newTestInfluxServer(map[string]string{
+		"SHOW RETENTION POLICIES": `{"results":[{"statement_id":0,"series":[{"columns":["name","duration"],"values":[["autogen","0s"]]}]}]}`,
+	}, &queries)
+	defer server.Close()
+
+	client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL})
+	if err := provisionDatabase(client, "metrics", "week", "7d", 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`CREATE DATABASE "metrics"`,
+		`SHOW RETENTION POLICIES ON "metrics"`,
+		`CREATE RETENTION POLICY "week" ON "metrics" DURATION 7d REPLICATION 1 DEFAULT`,
+		`write metrics.week`,
+	}
+	if strings.Join(queries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(queries, "\n"))
+	}
+}
+
+func TestProvisionExistingDatabase(t *testing.T) {
+	var queries []string
+	server := newTestInfluxServer(map[string]string{
+		"CREATE DATABASE":         `{"results":[{"statement_id":0,"error":"error authorizing query: requires admin privilege"}]}`,
+		"SHOW DATABASES":          `{"results":[{"statement_id":0,"series":[{"name":"databases","columns":["name"],"values":[["metrics"]]}]}]}`,
+		"SHOW RETENTION POLICIES": `{"results":[{"statement_id":0,"series":[{"columns":["name"],"values":[["week"]]}]}]}`,
+	}, &queries)
+	defer server.Close()
+
+	client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL})
+	if err := provisionDatabase(client, "metrics", "week", "7d", 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range queries {
+		if strings.HasPrefix(q, "CREATE RETENTION POLICY") {
+			t.Error("Existing retention policy shouldn't be created")
+		}
+	}
+
+	if err := provisionDatabase(client, "other", "", "", 1, false); err == nil {
+		t.Error("Missing database without admin privilege should be reported")
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if q := quoteIdent(`my "db"`); q != `"my \"db\""` {
+		t.Error("Unexpected quoting", q)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// p2Quantile estimates a quantile of a stream of values in constant memory
+// with the P² algorithm (Jain and Chlamtac, 1985): five markers track the
+// minimum, the maximum, the quantile and two values halfway to it, and
+// their heights are adjusted with a piecewise-parabolic formula.
+type p2Quantile struct {
+	p     float64
+	count int
+	q     [5]float64 // marker heights
+	n     [5]float64 // marker positions
+	np    [5]float64 // desired marker positions
+	dn    [5]float64 // increments of the desired positions
+}
+
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{p: p, dn: [5]float64{0, p / 2, p, (1 + p) / 2, 1}}
+}
+
+func (e *p2Quantile) add(x float64) {
+	// The first values are kept as they are
+	if e.count < 5 {
+		e.q[e.count] = x
+		e.count++
+		if e.count == 5 {
+			sort.Float64s(e.q[:])
+			e.n = [5]float64{1, 2, 3, 4, 5}
+			e.np = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+		}
+		return
+	}
+	e.count++
+
+	// Find the cell of x, extending the extreme markers if needed
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+		k = 0
+	case x >= e.q[4]:
+		e.q[4] = x
+		k = 3
+	default:
+		for k = 0; x >= e.q[k+1]; k++ {
+		}
+	}
+
+	for i := k + 1; i < 5; i++ {
+		e.n[i]++
+	}
+	for i := range e.np {
+		e.np[i] += e.dn[i]
+	}
+
+	// Move the middle markers towards their desired positions
+	for i := 1; i < 4; i++ {
+		d := e.np[i] - e.n[i]
+		if (d >= 1 && e.n[i+1]-e.n[i] > 1) || (d <= -1 && e.n[i-1]-e.n[i] < -1) {
+			s := math.Copysign(1, d)
+			q := e.parabolic(i, s)
+			if q <= e.q[i-1] || q >= e.q[i+1] {
+				q = e.linear(i, s)
+			}
+			e.q[i] = q
+			e.n[i] += s
+		}
+	}
+}
+
+func (e *p2Quantile) parabolic(i int, d float64) float64 {
+	return e.q[i] + d/(e.n[i+1]-e.n[i-1])*
+		((e.n[i]-e.n[i-1]+d)*(e.q[i+1]-e.q[i])/(e.n[i+1]-e.n[i])+
+			(e.n[i+1]-e.n[i]-d)*(e.q[i]-e.q[i-1])/(e.n[i]-e.n[i-1]))
+}
+
+func (e *p2Quantile) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.q[i] + d*(e.q[j]-e.q[i])/(e.n[j]-e.n[i])
+}
+
+// value returns the estimate, which is exact (nearest rank) for less than
+// five values.
+func (e *p2Quantile) value() (float64, bool) {
+	if e.count == 0 {
+		return 0, false
+	}
+	if e.count < 5 {
+		values := append([]float64{}, e.q[:e.count]...)
+		sort.Float64s(values)
+		i := int(math.Ceil(e.p*float64(e.count))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i], true
+	}
+	return e.q[2], true
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestP2Quantile(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	estimators := map[float64]*p2Quantile{}
+	for _, p := range []float64{0.5, 0.95, 0.99} {
+		estimators[p] = newP2Quantile(p)
+	}
+
+	for _, i := range r.Perm(10000) {
+		for _, e := range estimators {
+			e.add(float64(i + 1))
+		}
+	}
+
+	for p, e := range estimators {
+		v, ok := e.value()
+		if expected := p * 10000; !ok || math.Abs(v-expected) > 100 {
+			t.Errorf("Expected p%v close to %v, got %v", p*100, expected, v)
+		}
+	}
+}
+
+func TestP2QuantileFewValues(t *testing.T) {
+	e := newP2Quantile(0.5)
+	if _, ok := e.value(); ok {
+		t.Error("Expected no value without samples")
+	}
+
+	for _, v := range []float64{7, 1, 3} {
+		e.add(v)
+	}
+	if v, _ := e.value(); v != 3 {
+		t.Error("Expected median 3, got", v)
+	}
+}
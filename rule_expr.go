@@ -0,0 +1,347 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule expressions are parsed into a tree of ruleExpr. Values are float64,
+// comparisons and logical operators return 1 for true and 0 for false.
+//
+//	or         = and { ("or" | "||") and }
+//	and        = comparison { ("and" | "&&") comparison }
+//	comparison = sum [ ("<" | "<=" | ">" | ">=" | "==" | "!=") sum ]
+//	sum        = product { ("+" | "-") product }
+//	product    = unary { ("*" | "/") unary }
+//	unary      = [ "-" | "!" ] primary
+//	primary    = number | variable | measurement "." field
+//	           | "delta" "(" measurement "." field ")" | "(" or ")"
+type ruleExpr interface {
+	eval(env *ruleEnv) (float64, error)
+}
+
+type ruleNumber float64
+
+type ruleVariable string
+
+// ruleField refers to a field of a measurement; with delta, to its change
+// since the previous collection.
+type ruleField struct {
+	measurement string
+	field       string
+	delta       bool
+}
+
+type ruleUnary struct {
+	op string
+	x  ruleExpr
+}
+
+type ruleBinary struct {
+	op   string
+	x, y ruleExpr
+}
+
+func (n ruleNumber) eval(env *ruleEnv) (float64, error) {
+	return float64(n), nil
+}
+
+func (v ruleVariable) eval(env *ruleEnv) (float64, error) {
+	if x, ok := env.variables[string(v)]; ok {
+		return x, nil
+	}
+	return 0, fmt.Errorf("unknown variable `%s'", string(v))
+}
+
+func (f ruleField) eval(env *ruleEnv) (float64, error) {
+	return env.field(f)
+}
+
+func (u ruleUnary) eval(env *ruleEnv) (float64, error) {
+	x, err := u.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if u.op == "!" {
+		return boolValue(x == 0), nil
+	}
+	return -x, nil
+}
+
+func (b ruleBinary) eval(env *ruleEnv) (float64, error) {
+	x, err := b.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	// Short-circuit logical operators
+	switch {
+	case b.op == "and" && x == 0:
+		return 0, nil
+	case b.op == "or" && x != 0:
+		return 1, nil
+	}
+
+	y, err := b.y.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	case "<":
+		return boolValue(x < y), nil
+	case "<=":
+		return boolValue(x <= y), nil
+	case ">":
+		return boolValue(x > y), nil
+	case ">=":
+		return boolValue(x >= y), nil
+	case "==":
+		return boolValue(x == y), nil
+	case "!=":
+		return boolValue(x != y), nil
+	case "and", "or":
+		return boolValue(y != 0), nil
+	}
+	return 0, fmt.Errorf("unknown operator `%s'", b.op)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ruleParser is a recursive descent parser of rule expressions.
+type ruleParser struct {
+	tokens []string
+	pos    int
+	fields []ruleField
+}
+
+func parseRuleExpr(s string) (ruleExpr, []ruleField, error) {
+	tokens, err := tokenizeRule(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &ruleParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected `%s'", p.tokens[p.pos])
+	}
+	return e, p.fields, nil
+}
+
+// tokenizeRule splits an expression into numbers, identifiers and
+// operators.
+func tokenizeRule(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1])):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' ||
+				(s[j] == '-' || s[j] == '+') && s[j-1] == 'e') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case i+1 < len(s) && strings.Contains("<= >= == != && ||", s[i:i+2]):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/()<>!.", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character `%c'", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *ruleParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *ruleParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *ruleParser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("expected `%s', got `%s'", token, t)
+	}
+	return nil
+}
+
+// parseBinary parses a left-associative chain of operators. Aliases map
+// alternative spellings of operators.
+func (p *ruleParser) parseBinary(operand func() (ruleExpr, error), aliases map[string]string, ops ...string) (ruleExpr, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if alias, ok := aliases[op]; ok {
+			op = alias
+		}
+		if !stringInSlice(op, ops) {
+			return x, nil
+		}
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = ruleBinary{op, x, y}
+	}
+}
+
+func (p *ruleParser) parseOr() (ruleExpr, error) {
+	return p.parseBinary(p.parseAnd, map[string]string{"||": "or"}, "or")
+}
+
+func (p *ruleParser) parseAnd() (ruleExpr, error) {
+	return p.parseBinary(p.parseComparison, map[string]string{"&&": "and"}, "and")
+}
+
+func (p *ruleParser) parseComparison() (ruleExpr, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek(); stringInSlice(op, []string{"<", "<=", ">", ">=", "==", "!="}) {
+		p.next()
+		y, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return ruleBinary{op, x, y}, nil
+	}
+	return x, nil
+}
+
+func (p *ruleParser) parseSum() (ruleExpr, error) {
+	return p.parseBinary(p.parseProduct, nil, "+", "-")
+}
+
+func (p *ruleParser) parseProduct() (ruleExpr, error) {
+	return p.parseBinary(p.parseUnary, nil, "*", "/")
+}
+
+func (p *ruleParser) parseUnary() (ruleExpr, error) {
+	if op := p.peek(); op == "-" || op == "!" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return ruleUnary{op, x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *ruleParser) parsePrimary() (ruleExpr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case t == "delta" && p.peek() == "(":
+		p.next()
+		f, err := p.parseField(p.next())
+		if err != nil {
+			return nil, err
+		}
+		f.delta = true
+		p.fields[len(p.fields)-1] = f
+		return f, p.expect(")")
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		x, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number `%s'", t)
+		}
+		return ruleNumber(x), nil
+	case unicode.IsLetter(rune(t[0])) || t[0] == '_':
+		if p.peek() == "." {
+			return p.parseField(t)
+		}
+		return ruleVariable(t), nil
+	}
+	return nil, fmt.Errorf("unexpected `%s'", t)
+}
+
+func (p *ruleParser) parseField(measurement string) (ruleField, error) {
+	if err := p.expect("."); err != nil {
+		return ruleField{}, err
+	}
+	field := p.next()
+	if field == "" || !(unicode.IsLetter(rune(field[0])) || field[0] == '_') {
+		return ruleField{}, fmt.Errorf("expected field name after `%s.', got `%s'", measurement, field)
+	}
+
+	f := ruleField{measurement: measurement, field: field}
+	p.fields = append(p.fields, f)
+	return f, nil
+}
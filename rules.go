@@ -0,0 +1,446 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rule is an alert condition evaluated on each collection, for each series
+// of the first measurement it refers to. Other measurements it refers to
+// must have a single series, e.g. load or mem.
+type rule struct {
+	name        string
+	expression  string
+	condition   ruleExpr
+	value       ruleExpr
+	measurement string
+	fields      []ruleField
+	duration    time.Duration
+}
+
+// singleSeries lists the measurements with a single series, which rules can
+// refer to besides their own measurement.
+var singleSeries = []string{"cpu", "mem", "swap", "uptime", "load"}
+
+// errNoPrevious is returned for the change of a counter seen for the first
+// time; such rules are silently skipped.
+var errNoPrevious = errors.New("no previous value")
+
+// ruleVariables returns the variables rules can refer to.
+func ruleVariables() map[string]float64 {
+	return map[string]float64{"cpu_count": float64(runtime.NumCPU())}
+}
+
+var ruleForPattern = regexp.MustCompile(`^(.*?)\s+for\s+(\S+)$`)
+
+// parseRule parses a line of a rules file: `[<name>:] <expression> [for
+// <duration>]'. The name defaults to the expression.
+func parseRule(line string) (*rule, error) {
+	r := &rule{}
+
+	if i := strings.Index(line, ":"); i >= 0 {
+		r.name = strings.TrimSpace(line[:i])
+		line = line[i+1:]
+	}
+	line = strings.TrimSpace(line)
+
+	if m := ruleForPattern.FindStringSubmatch(line); m != nil {
+		d, err := time.ParseDuration(m[2])
+		if err != nil {
+			return nil, err
+		}
+		line, r.duration = m[1], d
+	}
+
+	condition, fields, err := parseRuleExpr(line)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", line, err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s: no measurement field", line)
+	}
+
+	r.expression = line
+	r.condition = condition
+	r.value = ruleValue(condition)
+	r.measurement = fields[0].measurement
+	r.fields = fields
+	if r.name == "" {
+		r.name = line
+	}
+
+	if err := r.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", line, err)
+	}
+	return r, nil
+}
+
+// check reports the names of a rule that can't be resolved on collection,
+// so that the rule would never fire.
+func (r *rule) check() error {
+	variables := ruleVariables()
+	for _, v := range ruleVariableNames(r.condition) {
+		if _, ok := variables[v]; !ok {
+			return fmt.Errorf("unknown variable `%s'", v)
+		}
+	}
+
+	for _, f := range r.fields {
+		cl, ok := findCollector(f.measurement)
+		if !ok {
+			return fmt.Errorf("unknown measurement `%s'", f.measurement)
+		}
+
+		found := false
+		for _, clField := range cl.fields {
+			found = found || clField.Name == f.field
+		}
+		if !found {
+			return fmt.Errorf("unknown field `%s.%s'", f.measurement, f.field)
+		}
+
+		if f.delta && !isCounter(f.measurement, f.field) {
+			return fmt.Errorf("%s.%s is not a counter", f.measurement, f.field)
+		}
+		if f.measurement != r.measurement && !stringInSlice(f.measurement, singleSeries) {
+			return fmt.Errorf("%s has several series, only the first measurement or one of %s can be used", f.measurement, strings.Join(singleSeries, ", "))
+		}
+	}
+	return nil
+}
+
+// ruleVariableNames returns the variables an expression refers to.
+func ruleVariableNames(e ruleExpr) []string {
+	switch e := e.(type) {
+	case ruleVariable:
+		return []string{string(e)}
+	case ruleUnary:
+		return ruleVariableNames(e.x)
+	case ruleBinary:
+		return append(ruleVariableNames(e.x), ruleVariableNames(e.y)...)
+	}
+	return nil
+}
+
+// ruleValue returns the expression reported as the value of an alert: the
+// left operand of the first comparison.
+func ruleValue(e ruleExpr) ruleExpr {
+	b, ok := e.(ruleBinary)
+	if !ok {
+		return e
+	}
+	switch b.op {
+	case "and", "or":
+		return ruleValue(b.x)
+	case "<", "<=", ">", ">=", "==", "!=":
+		return b.x
+	}
+	return e
+}
+
+// loadRules reads a rules file, one rule per line; empty lines and lines
+// starting with # are ignored.
+func loadRules(path string) ([]*rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []*rule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// ruleEnv resolves the names of an expression for one series.
+type ruleEnv struct {
+	point     *influx.Point
+	series    map[string][]*influx.Point
+	variables map[string]float64
+	// current and previous hold the raw fields of each series in this and
+	// the previous collection, by series key.
+	current, previous map[string]map[string]interface{}
+}
+
+// field returns the value of a field as written to InfluxDB; for counters,
+// delta returns the difference to the previous collection instead.
+func (env *ruleEnv) field(f ruleField) (float64, error) {
+	p := env.point
+	if p.Name() != f.measurement {
+		points := env.series[f.measurement]
+		if len(points) != 1 {
+			return 0, fmt.Errorf("%d series of %s", len(points), f.measurement)
+		}
+		p = points[0]
+	}
+
+	if f.delta && !isCounter(p.Name(), f.field) {
+		return 0, fmt.Errorf("%s.%s is not a counter", f.measurement, f.field)
+	}
+
+	key := seriesKey(p)
+	fields, ok := env.current[key]
+	if !ok {
+		var err error
+		if fields, err = rawFields(p); err != nil {
+			return 0, err
+		}
+	}
+
+	v, ok := numericValue(fields[f.field])
+	if !ok {
+		return 0, fmt.Errorf("no numeric field %s.%s", f.measurement, f.field)
+	}
+	if !f.delta {
+		return v, nil
+	}
+
+	// Counters aren't all diffed by the reporter, e.g. CPU times
+	previous, ok := numericValue(env.previous[key][f.field])
+	if !ok {
+		return 0, errNoPrevious
+	}
+	return v - previous, nil
+}
+
+// alertEvent is a state change of a rule for one series.
+type alertEvent struct {
+	Rule       string            `json:"rule"`
+	State      string            `json:"state"`
+	Expression string            `json:"expression"`
+	Value      float64           `json:"value"`
+	Tags       map[string]string `json:"tags"`
+	Since      time.Time         `json:"since"`
+	Time       time.Time         `json:"time"`
+
+	// key identifies the rule and series
+	key string
+}
+
+func (ev alertEvent) point() (*influx.Point, error) {
+	tags := map[string]string{"rule": ev.Rule, "state": ev.State}
+	for k, v := range ev.Tags {
+		tags[k] = v
+	}
+	return influx.NewPoint(
+		"alerts",
+		tags,
+		map[string]interface{}{
+			"value":      ev.Value,
+			"expression": ev.Expression,
+		},
+		ev.Time,
+	)
+}
+
+type alertState struct {
+	pending time.Time
+	firing  bool
+	// event is the firing event, updated on each collection
+	event alertEvent
+}
+
+// ruleEngine evaluates rules and tracks the state of each rule and series:
+// a condition has to hold for the duration of the rule to fire, and the
+// alert is resolved as soon as it doesn't hold any more, or its series is
+// no longer collected.
+type ruleEngine struct {
+	rules     []*rule
+	variables map[string]float64
+	states    map[string]*alertState
+	notifier  *notifier
+
+	// previous holds the raw fields of the previous collection, by series
+	previous map[string]map[string]interface{}
+	// errors holds the last evaluation error of each rule
+	errors map[string]string
+}
+
+func newRuleEngine(rules []*rule) *ruleEngine {
+	return &ruleEngine{
+		rules:     rules,
+		variables: ruleVariables(),
+		states:    make(map[string]*alertState),
+		previous:  make(map[string]map[string]interface{}),
+		errors:    make(map[string]string),
+	}
+}
+
+// evaluate checks the rules on the points of a collection made at now and
+// returns the state changes.
+func (e *ruleEngine) evaluate(points []*influx.Point, now time.Time) []alertEvent {
+	series := make(map[string][]*influx.Point)
+	current := make(map[string]map[string]interface{})
+	for _, p := range points {
+		series[p.Name()] = append(series[p.Name()], p)
+		if fields, err := rawFields(p); err == nil {
+			current[seriesKey(p)] = fields
+		}
+	}
+
+	var events []alertEvent
+	seen := make(map[string]bool)
+	for _, r := range e.rules {
+		for _, p := range series[r.measurement] {
+			key := r.name + "#" + seriesKey(p)
+			seen[key] = true
+
+			env := &ruleEnv{point: p, series: series, variables: e.variables, current: current, previous: e.previous}
+			x, err := r.condition.eval(env)
+			if err == errNoPrevious {
+				continue
+			} else if err != nil {
+				e.evalError(r, err)
+				continue
+			}
+
+			state, ok := e.states[key]
+			if !ok {
+				state = &alertState{}
+				e.states[key] = state
+			}
+
+			var event string
+			if x != 0 {
+				if state.pending.IsZero() {
+					state.pending = now
+				}
+				if !state.firing && now.Sub(state.pending) >= r.duration {
+					state.firing = true
+					event = "firing"
+				}
+			} else {
+				if state.firing {
+					event = "resolved"
+				}
+				delete(e.states, key)
+			}
+
+			// Resolved alerts are still marked as firing, but their state
+			// is gone
+			if !state.firing {
+				continue
+			}
+
+			value, _ := r.value.eval(env)
+			state.event = alertEvent{
+				Rule:       r.name,
+				State:      "firing",
+				Expression: r.expression,
+				Value:      value,
+				Tags:       p.Tags(),
+				Since:      state.pending,
+				Time:       now,
+				key:        key,
+			}
+			if event == "" {
+				continue
+			}
+
+			ev := state.event
+			ev.State = event
+			log.WithField("value", value).Warnf("Alert %s %s on %s.", r.name, event, seriesKey(p))
+			events = append(events, ev)
+		}
+	}
+
+	events = append(events, e.resolveMissing(seen, now)...)
+	e.previous = current
+
+	if e.notifier != nil {
+		e.notifier.notify(events, e.firing(), now)
+	}
+	return events
+}
+
+// resolveMissing forgets the states of series that weren't collected, e.g.
+// an unmounted filesystem, and returns the resolved alerts.
+func (e *ruleEngine) resolveMissing(seen map[string]bool, now time.Time) []alertEvent {
+	var missing []string
+	for key := range e.states {
+		if !seen[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+
+	var events []alertEvent
+	for _, key := range missing {
+		state := e.states[key]
+		delete(e.states, key)
+		if !state.firing {
+			continue
+		}
+
+		ev := state.event
+		ev.State = "resolved"
+		ev.Time = now
+		log.Warnf("Alert %s resolved on %s, which is no longer collected.", ev.Rule, strings.TrimPrefix(key, ev.Rule+"#"))
+		events = append(events, ev)
+	}
+	return events
+}
+
+// evalError logs why a rule can't be evaluated: as a warning the first time
+// and whenever the reason changes, so that a rule never fails silently.
+func (e *ruleEngine) evalError(r *rule, err error) {
+	if e.errors[r.name] == err.Error() {
+		log.WithError(err).Debugf("Cannot evaluate rule %s.", r.name)
+		return
+	}
+	e.errors[r.name] = err.Error()
+	log.WithError(err).Warnf("Cannot evaluate rule %s.", r.name)
+}
+
+// firing returns the alerts that are firing, with their latest value.
+func (e *ruleEngine) firing() []alertEvent {
+	var events []alertEvent
+	for _, state := range e.states {
+		if state.firing {
+			events = append(events, state.event)
+		}
+	}
+	return events
+}
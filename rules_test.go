@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestRulePoint(t *testing.T, name string, tags map[string]string, fields map[string]interface{}) *influxClient.Point {
+	point, err := influxClient.NewPoint(name, tags, fields, time.Now())
+	if err != nil {
+		t.Fatal("Cannot create point:", err)
+	}
+	return point
+}
+
+func TestParseRuleExpr(t *testing.T) {
+	env := &ruleEnv{
+		point:     newTestRulePoint(t, "test_fs", map[string]string{}, map[string]interface{}{"free": 4.0, "total": 100.0}),
+		variables: map[string]float64{"cpu_count": 4},
+	}
+
+	for expression, expected := range map[string]float64{
+		"test_fs.free / test_fs.total < 0.05":       1,
+		"1 + 2 * 3":                                 7,
+		"(1 + 2) * 3":                               9,
+		"-test_fs.free + 1e1":                       6,
+		"10 - 4 - 3":                                3,
+		"cpu_count > 2 and test_fs.free > 5":        0,
+		"cpu_count > 2 || test_fs.free > 5":         1,
+		"!(test_fs.free >= 4) or test_fs.free != 4": 0,
+	} {
+		e, _, err := parseRuleExpr(expression)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %v", expression, err)
+			continue
+		}
+		if x, err := e.eval(env); err != nil || x != expected {
+			t.Errorf("Expected %s = %v, got %v (%v)", expression, expected, x, err)
+		}
+	}
+
+	for _, expression := range []string{"", "1 +", "(1", "test_fs.", "1 $ 2", "1 2"} {
+		if _, _, err := parseRuleExpr(expression); err == nil {
+			t.Errorf("Expected error parsing %q", expression)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := parseRule("disk full: mounts.free/mounts.total < 0.05 for 5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.name != "disk full" || r.expression != "mounts.free/mounts.total < 0.05" ||
+		r.duration != 5*time.Minute || r.measurement != "mounts" {
+		t.Error("Unexpected rule:", r)
+	}
+
+	if r, err = parseRule("load.five > 2*cpu_count"); err != nil || r.name != "load.five > 2*cpu_count" || r.duration != 0 {
+		t.Error("Unexpected rule:", r, err)
+	}
+
+	if _, err := parseRule("cpu_count > 2"); err == nil {
+		t.Error("Expected error for rule without field")
+	}
+
+	// Rules that would never fire are rejected when loaded
+	for _, line := range []string{
+		"load.five > cpu_cont",
+		"load.fiv > 2",
+		"test_fs.free > 2",
+		"load.five > 2 and network.recv_errs > 0",
+		"delta(mounts.free) < 0",
+	} {
+		if _, err := parseRule(line); err == nil {
+			t.Errorf("Expected error for %q", line)
+		}
+	}
+}
+
+func TestRuleEngine(t *testing.T) {
+	f, _ := ioutil.TempFile("", "rules")
+	defer os.Remove(f.Name())
+	f.WriteString("# Test rules\n\nhigh: load.five > 2 * cpu_count for 2m\nfull: mounts.free / mounts.total < 0.1 and load.five > 0\n")
+	f.Close()
+
+	r, err := loadRules(f.Name())
+	if err != nil || len(r) != 2 {
+		t.Fatal("Cannot load rules:", r, err)
+	}
+	e := newRuleEngine(r)
+	e.variables["cpu_count"] = 2
+
+	collect := func(load float64, free ...float64) []*influxClient.Point {
+		points := []*influxClient.Point{
+			newTestRulePoint(t, "load", map[string]string{}, map[string]interface{}{"five": load}),
+		}
+		for i, v := range free {
+			points = append(points, newTestRulePoint(t, "mounts", map[string]string{"mountpoint": string(rune('a' + i))},
+				map[string]interface{}{"free": v, "total": 100.0}))
+		}
+		return points
+	}
+
+	start := time.Now()
+	steps := []struct {
+		points   []*influxClient.Point
+		offset   time.Duration
+		expected []string
+	}{
+		{collect(5, 50, 5), 0, []string{"full firing b"}},
+		{collect(5, 50, 5), time.Minute, nil},
+		{collect(5, 5, 50), 2 * time.Minute, []string{"high firing ", "full firing a", "full resolved b"}},
+		{collect(1, 5, 50), 3 * time.Minute, []string{"high resolved "}},
+	}
+
+	for i, step := range steps {
+		events := e.evaluate(step.points, start.Add(step.offset))
+		var actual []string
+		for _, ev := range events {
+			actual = append(actual, ev.Rule+" "+ev.State+" "+ev.Tags["mountpoint"])
+		}
+		if !equalStrings(actual, step.expected) {
+			t.Errorf("Step %d: expected %v, got %v", i, step.expected, actual)
+		}
+	}
+
+	events := e.evaluate(collect(5, 50, 50), start.Add(4*time.Minute))
+	if len(events) != 1 || events[0].Value != 0.5 || !events[0].Since.Equal(start.Add(2*time.Minute)) {
+		t.Fatal("Expected full resolved on a, got", events)
+	}
+	p, err := events[0].point()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "alerts" || p.Tags()["rule"] != "full" || p.Tags()["state"] != "resolved" || p.Tags()["mountpoint"] != "a" {
+		t.Error("Unexpected alert point:", p)
+	}
+
+	// Alerts of series that are no longer collected are resolved
+	e.evaluate(collect(1, 5, 50), start.Add(5*time.Minute))
+	events = e.evaluate(collect(1), start.Add(6*time.Minute))
+	if len(events) != 1 || events[0].Rule != "full" || events[0].State != "resolved" || events[0].Tags["mountpoint"] != "a" {
+		t.Fatal("Expected full resolved on a, got", events)
+	}
+	if len(e.firing()) != 0 || len(e.states) != 0 {
+		t.Error("Expected no state left, got", e.states)
+	}
+}
+
+// TestRuleDelta uses the unsigned fields of the cpu collector, which are
+// neither diffed nor numbers in the point.
+func TestRuleDelta(t *testing.T) {
+	r, err := parseRule("idle: delta(cpu.idle) / delta(cpu.total) > 0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newRuleEngine([]*rule{r})
+
+	collect := func(idle, total uint64) []*influxClient.Point {
+		return []*influxClient.Point{newTestRulePoint(t, "cpu", map[string]string{"cpuid": "all"},
+			map[string]interface{}{"idle": idle, "total": total})}
+	}
+
+	start := time.Now()
+	for i, step := range []struct {
+		idle, total uint64
+		expected    []string
+	}{
+		{90000, 100000, nil},
+		{90060, 100100, []string{"firing"}},
+		{90100, 100200, []string{"resolved"}},
+	} {
+		var actual []string
+		for _, ev := range e.evaluate(collect(step.idle, step.total), start.Add(time.Duration(i)*time.Minute)) {
+			actual = append(actual, ev.State)
+		}
+		if !equalStrings(actual, step.expected) {
+			t.Errorf("Step %d: expected %v, got %v", i, step.expected, actual)
+		}
+	}
+}
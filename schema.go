@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Field types, as named by InfluxDB.
+const (
+	fieldFloat   = "float"
+	fieldInteger = "integer"
+	fieldString  = "string"
+)
+
+// field describes one field of a measurement. Counter is set for
+// cumulative counters, as opposed to gauges.
+type field struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Counter bool   `json:"-"`
+}
+
+// typedFields describes gauges of the same type.
+func typedFields(fieldType string, names ...string) []field {
+	var fields []field
+	for _, name := range names {
+		fields = append(fields, field{name, fieldType, false})
+	}
+	return fields
+}
+
+// counterFields describes counters of the same type.
+func counterFields(fieldType string, names ...string) []field {
+	fields := typedFields(fieldType, names...)
+	for i := range fields {
+		fields[i].Counter = true
+	}
+	return fields
+}
+
+// withGauges marks the named fields as gauges.
+func withGauges(fields []field, names ...string) []field {
+	for i := range fields {
+		for _, name := range names {
+			if fields[i].Name == name {
+				fields[i].Counter = false
+			}
+		}
+	}
+	return fields
+}
+
+type fieldSchema struct {
+	field
+	Kind string `json:"kind"`
+}
+
+type measurementSchema struct {
+	Measurement string        `json:"measurement"`
+	Tags        []string      `json:"tags"`
+	Fields      []fieldSchema `json:"fields"`
+}
+
+func newMeasurementSchema(cl collector) measurementSchema {
+	s := measurementSchema{
+		Measurement: cl.name,
+		Tags:        append(append([]string{}, cl.tags...), "fqdn"),
+	}
+	for _, f := range cl.fields {
+		kind := "gauge"
+		if f.Counter {
+			kind = "counter"
+		}
+		s.Fields = append(s.Fields, fieldSchema{f, kind})
+	}
+	return s
+}
+
+// listCollectors describes the measurement of every collector, as JSON or
+// as a table.
+func listCollectors(w io.Writer, format string) error {
+	var schemas []measurementSchema
+	for _, cl := range collectors {
+		schemas = append(schemas, newMeasurementSchema(cl))
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(schemas)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MEASUREMENT\tTAGS\tFIELD\tTYPE\tKIND")
+	for _, s := range schemas {
+		for _, f := range s.Fields {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Measurement, strings.Join(s.Tags, ","), f.Name, f.Type, f.Kind)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/influxdata/influxdb/models"
+	"sort"
+	"testing"
+)
+
+// TestCollectorSchema checks the declared schema against the points the
+// collectors actually produce on this machine.
+func TestCollectorSchema(t *testing.T) {
+	types := map[models.FieldType]string{
+		models.Float:   fieldFloat,
+		models.Integer: fieldInteger,
+		models.String:  fieldString,
+	}
+
+	for _, cl := range collectors {
+		var res collectionResult
+		// Counters report data from the second collection on
+		for i := 0; i < 2; i++ {
+			ch := make(chan collectionResult, 1)
+			cl.collect(ch)
+			res = <-ch
+		}
+		if res.err != nil || len(res.point) == 0 {
+			t.Logf("Skipping %s, no data: %v", cl.name, res.err)
+			continue
+		}
+
+		s := newMeasurementSchema(cl)
+		for _, p := range res.point {
+			parsed, err := models.ParsePointsString(p.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tags []string
+			for _, tag := range parsed[0].Tags() {
+				tags = append(tags, string(tag.Key))
+			}
+			expected := append([]string{}, s.Tags...)
+			sort.Strings(expected)
+			if !equalStrings(tags, expected) {
+				t.Errorf("Expected tags %v for %s, got %v", expected, cl.name, tags)
+			}
+
+			var fields []field
+			iter := parsed[0].FieldIterator()
+			for iter.Next() {
+				fields = append(fields, field{Name: string(iter.FieldKey()), Type: types[iter.Type()]})
+			}
+			if len(fields) != len(cl.fields) {
+				t.Errorf("Expected fields %v for %s, got %v", cl.fields, cl.name, fields)
+			}
+			for _, f := range cl.fields {
+				found := false
+				for _, actual := range fields {
+					found = found || (actual.Name == f.Name && actual.Type == f.Type)
+				}
+				if !found {
+					t.Errorf("Expected field %v for %s, got %v", f, cl.name, fields)
+				}
+			}
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListCollectorsJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := listCollectors(&b, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var schemas []measurementSchema
+	if err := json.Unmarshal(b.Bytes(), &schemas); err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != len(collectors) {
+		t.Fatalf("Expected %d collectors, got %d", len(collectors), len(schemas))
+	}
+	if s := schemas[6]; s.Measurement != "network" || s.Fields[0].Name != "recv_bytes" || s.Fields[0].Type != fieldInteger || s.Fields[0].Kind != "counter" {
+		t.Error("Unexpected network schema:", s)
+	}
+
+	kinds := make(map[string]string)
+	for _, s := range schemas {
+		for _, f := range s.Fields {
+			kinds[s.Measurement+"."+f.Name] = f.Kind
+		}
+	}
+	for name, kind := range map[string]string{
+		"cpu.idle":        "counter",
+		"disks.read_ios":  "counter",
+		"disks.in_flight": "gauge",
+		"mounts.free":     "gauge",
+		"mounts.total":    "gauge",
+	} {
+		if kinds[name] != kind {
+			t.Errorf("Expected %s to be a %s, got %q", name, kind, kinds[name])
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/cloudfoundry/gosigar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fsStats holds the fields of statfs used by the mounts collector.
+type fsStats struct {
+	Bfree  uint64 `json:"bfree"`
+	Blocks uint64 `json:"blocks"`
+	Bsize  int64  `json:"bsize"`
+}
+
+// procSource provides the /proc files and file system statistics the
+// collectors read, and the time of the current collection and the fqdn of
+// the host it is made on. begin is called before each collection and end
+// after it.
+type procSource interface {
+	begin() error
+	open(name string) (io.ReadCloser, error)
+	statfs(path string) (fsStats, error)
+	now() time.Time
+	fqdn() string
+	end() error
+}
+
+// source is where collectors read from: the live system by default, or an
+// archive with -replay.
+var source procSource = liveSource{}
+
+type liveSource struct{}
+
+func (liveSource) begin() error {
+	return nil
+}
+
+func (liveSource) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join("/proc", name))
+}
+
+func (liveSource) statfs(path string) (fsStats, error) {
+	fs := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return fsStats{}, err
+	}
+	return fsStats{Bfree: fs.Bfree, Blocks: fs.Blocks, Bsize: int64(fs.Bsize)}, nil
+}
+
+func (liveSource) now() time.Time {
+	return time.Now()
+}
+
+func (liveSource) fqdn() string {
+	return getFqdn()
+}
+
+func (liveSource) end() error {
+	return nil
+}
+
+// sigarFiles are the /proc files read by gosigar for the cpu, cpus, mem,
+// swap and load collectors.
+var sigarFiles = []string{"stat", "meminfo", "loadavg"}
+
+// writeSigarFiles writes the files read by gosigar to dir; missing files
+// are removed so that their collectors fail.
+func writeSigarFiles(dir string, files map[string]string) error {
+	for _, name := range sigarFiles {
+		path := filepath.Join(dir, name)
+		if data, ok := files[name]; ok {
+			if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+				return err
+			}
+		} else {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// procSnapshot holds the sources read during one collection.
+type procSnapshot struct {
+	Time   time.Time          `json:"time"`
+	Fqdn   string             `json:"fqdn,omitempty"`
+	Files  map[string]string  `json:"files"`
+	Statfs map[string]fsStats `json:"statfs,omitempty"`
+}
+
+// recordSource reads the live system and appends the sources of each
+// collection to an archive, one JSON snapshot per line. gosigar is pointed
+// to a copy of its files, so that the collectors read what is recorded.
+type recordSource struct {
+	liveSource
+	file *os.File
+	dir  string
+
+	mutex    sync.Mutex
+	snapshot procSnapshot
+}
+
+func newRecordSource(path string) (*recordSource, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "influxdb_reporter")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	sigar.Procd = dir
+	return &recordSource{file: f, dir: dir}, nil
+}
+
+func (r *recordSource) begin() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.snapshot = procSnapshot{
+		Time:   time.Now(),
+		Fqdn:   getFqdn(),
+		Files:  make(map[string]string),
+		Statfs: make(map[string]fsStats),
+	}
+
+	for _, name := range sigarFiles {
+		if data, err := ioutil.ReadFile(filepath.Join("/proc", name)); err == nil {
+			r.snapshot.Files[name] = string(data)
+		}
+	}
+	return writeSigarFiles(r.dir, r.snapshot.Files)
+}
+
+// open reads the whole file so that the collector parses exactly what is
+// recorded.
+func (r *recordSource) open(name string) (io.ReadCloser, error) {
+	f, err := r.liveSource.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.snapshot.Files[name] = string(data)
+	r.mutex.Unlock()
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (r *recordSource) statfs(path string) (fsStats, error) {
+	fs, err := r.liveSource.statfs(path)
+	if err != nil {
+		return fs, err
+	}
+
+	r.mutex.Lock()
+	r.snapshot.Statfs[path] = fs
+	r.mutex.Unlock()
+	return fs, nil
+}
+
+func (r *recordSource) now() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.snapshot.Time
+}
+
+func (r *recordSource) fqdn() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.snapshot.Fqdn
+}
+
+func (r *recordSource) end() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data, err := json.Marshal(r.snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(data, '\n'))
+	return err
+}
+
+func (r *recordSource) Close() error {
+	os.RemoveAll(r.dir)
+	return r.file.Close()
+}
+
+// replaySource reads the snapshots of an archive, one per collection, with
+// their recorded time. begin returns io.EOF at the end of the archive. As
+// with recordSource, gosigar reads its files from a temporary directory.
+type replaySource struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	dir     string
+
+	snapshot procSnapshot
+}
+
+func newReplaySource(path string) (*replaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "influxdb_reporter")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	sigar.Procd = dir
+	return &replaySource{file: f, scanner: scanner, dir: dir}, nil
+}
+
+func (r *replaySource) begin() error {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	r.snapshot = procSnapshot{}
+	if err := json.Unmarshal(r.scanner.Bytes(), &r.snapshot); err != nil {
+		return err
+	}
+
+	return writeSigarFiles(r.dir, r.snapshot.Files)
+}
+
+func (r *replaySource) open(name string) (io.ReadCloser, error) {
+	data, ok := r.snapshot.Files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "replay", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader([]byte(data))), nil
+}
+
+func (r *replaySource) statfs(path string) (fsStats, error) {
+	fs, ok := r.snapshot.Statfs[path]
+	if !ok {
+		return fs, &os.PathError{Op: "replay statfs", Path: path, Err: os.ErrNotExist}
+	}
+	return fs, nil
+}
+
+func (r *replaySource) now() time.Time {
+	return r.snapshot.Time
+}
+
+// fqdn returns the recorded fqdn, or the local one for archives recorded
+// without it.
+func (r *replaySource) fqdn() string {
+	if r.snapshot.Fqdn == "" {
+		return getFqdn()
+	}
+	return r.snapshot.Fqdn
+}
+
+func (r *replaySource) end() error {
+	return nil
+}
+
+func (r *replaySource) Close() error {
+	os.RemoveAll(r.dir)
+	return r.file.Close()
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry/gosigar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectFrom(t *testing.T, collect collectionFunc) collectionResult {
+	ch := make(chan collectionResult, 1)
+	collect(ch)
+	return <-ch
+}
+
+func TestReplay(t *testing.T) {
+	defer func(s procSource, procd string) { source, sigar.Procd = s, procd }(source, sigar.Procd)
+
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.jsonl")
+
+	start := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	f, _ := os.Create(path)
+	enc := json.NewEncoder(f)
+	for i := 0; i < 2; i++ {
+		enc.Encode(procSnapshot{
+			Time: start.Add(time.Duration(i) * time.Second),
+			Fqdn: "replay.example.org",
+			Files: map[string]string{
+				"net/dev": "Inter-|   Receive\n face |bytes\n" +
+					"  eth_replay: " + strings.Repeat(string('1'+rune(i))+" ", 16) + "\n",
+				"mounts":  "/dev/replay /replay ext4 rw 0 0\n",
+				"loadavg": "0.50 0.25 0.10 1/100 1000\n",
+			},
+			Statfs: map[string]fsStats{"/replay": {Bfree: 10, Blocks: 40, Bsize: 4096}},
+		})
+	}
+	f.Close()
+
+	r, err := newReplaySource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	source = r
+
+	for i := 0; i < 2; i++ {
+		if err := source.begin(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Counters are reported from the second collection on
+		res := collectFrom(t, network)
+		if res.err != nil || len(res.point) != i {
+			t.Fatalf("Expected %d network point, got %v %v", i, res.point, res.err)
+		}
+		if i == 1 {
+			p := res.point[0]
+			fields, _ := p.Fields()
+			if v, _ := numericValue(fields["recv_bytes"]); p.Tags()["iface"] != "eth_replay" || p.Tags()["fqdn"] != "replay.example.org" || v != 1 || !p.Time().Equal(start.Add(time.Second)) {
+				t.Error("Unexpected replayed network point:", p, fields)
+			}
+		}
+
+		res = collectFrom(t, load)
+		if fields, _ := res.point[0].Fields(); res.err != nil || fields["five"] != 0.25 {
+			t.Error("Unexpected replayed load:", res.point, res.err)
+		}
+
+		res = collectFrom(t, mounts)
+		if i == 1 && (res.err != nil || len(res.point) != 1 || !strings.Contains(res.point[0].String(), `free="40960",total="163840"`)) {
+			t.Error("Unexpected replayed mounts:", res.point, res.err)
+		}
+	}
+
+	res := collectFrom(t, disks)
+	if res.err == nil {
+		t.Error("Expected error for a file missing from the archive")
+	}
+
+	if err := source.begin(); err != io.EOF {
+		t.Error("Expected end of archive, got", err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	defer func(s procSource, procd string) { source, sigar.Procd = s, procd }(source, sigar.Procd)
+
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.jsonl")
+
+	r, err := newRecordSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source = r
+
+	r.begin()
+	collectFrom(t, network)
+	collectFrom(t, load)
+	if err := r.end(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	var snapshot procSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Files["net/dev"] == "" || snapshot.Files["loadavg"] == "" {
+		t.Error("Expected net/dev and loadavg in snapshot, got", snapshot.Files)
+	}
+	if snapshot.Fqdn != getFqdn() {
+		t.Error("Expected the fqdn in snapshot, got", snapshot.Fqdn)
+	}
+}
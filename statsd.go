@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statsDPacketSize keeps datagrams below the usual Ethernet MTU.
+const statsDPacketSize = 1432
+
+// statsDOutput sends points to StatsD over UDP. Counter fields are sent as
+// counters with the difference to the previous collection, all others as
+// gauges. With dogStatsD set, the point's tags are sent as DogStatsD tags;
+// otherwise the tag values are part of the name.
+type statsDOutput struct {
+	prefix    string
+	dogStatsD bool
+
+	conn net.Conn
+	// last holds the previous raw value of counters, by series and field
+	last map[string]map[string]float64
+}
+
+func newStatsDOutput(addr, prefix string, dogStatsD bool) (*statsDOutput, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsDOutput{
+		prefix:    prefix,
+		dogStatsD: dogStatsD,
+		conn:      conn,
+		last:      make(map[string]map[string]float64),
+	}, nil
+}
+
+func (o *statsDOutput) String() string {
+	return "statsd"
+}
+
+func (o *statsDOutput) Write(points []*influx.Point) error {
+	var packet bytes.Buffer
+	for _, p := range points {
+		lines, err := o.format(p)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			if packet.Len() > 0 && packet.Len()+len(line)+1 > statsDPacketSize {
+				if _, err := o.conn.Write(packet.Bytes()); err != nil {
+					return err
+				}
+				packet.Reset()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+	}
+
+	if packet.Len() > 0 {
+		_, err := o.conn.Write(packet.Bytes())
+		return err
+	}
+	return nil
+}
+
+func (o *statsDOutput) Close() error {
+	return o.conn.Close()
+}
+
+// format returns one StatsD line per numeric field of the point. Counters
+// are only sent from their second collection on, and not when they were
+// reset.
+func (o *statsDOutput) format(p *influx.Point) ([]string, error) {
+	fields, err := rawFields(p)
+	if err != nil {
+		return nil, err
+	}
+
+	key := seriesKey(p)
+	last, ok := o.last[key]
+	if !ok {
+		last = make(map[string]float64)
+		o.last[key] = last
+	}
+
+	tags := p.Tags()
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	name := []string{}
+	if o.prefix != "" {
+		name = append(name, o.prefix)
+	}
+	name = append(name, statsDSanitize(p.Name()))
+
+	var suffix string
+	if o.dogStatsD {
+		var dogTags []string
+		for _, k := range keys {
+			dogTags = append(dogTags, statsDSanitize(k)+":"+statsDSanitize(tags[k]))
+		}
+		if len(dogTags) > 0 {
+			suffix = "|#" + strings.Join(dogTags, ",")
+		}
+	} else {
+		for _, k := range keys {
+			name = append(name, statsDSanitize(tags[k]))
+		}
+	}
+
+	var fieldKeys []string
+	for k := range fields {
+		fieldKeys = append(fieldKeys, k)
+	}
+	sort.Strings(fieldKeys)
+
+	var lines []string
+	for _, k := range fieldKeys {
+		value, ok := numericValue(fields[k])
+		if !ok {
+			continue
+		}
+		metricType := "g"
+		if isCounter(p.Name(), k) {
+			metricType = "c"
+			previous, seen := last[k]
+			last[k] = value
+			if !seen || value < previous {
+				continue
+			}
+			value -= previous
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s:%s|%s%s", strings.Join(name, "."), statsDSanitize(k),
+			strconv.FormatFloat(value, 'f', -1, 64), metricType, suffix))
+	}
+	return lines, nil
+}
+
+// statsDSanitize replaces characters with a meaning in the StatsD protocol
+// or in metric paths.
+func statsDSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', ' ', '\n', '/':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDFormat(t *testing.T) {
+	newNetwork := func(bytes int) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"network",
+			map[string]string{"fqdn": "koala.example.com", "iface": "eth0"},
+			map[string]interface{}{"recv_bytes": bytes},
+			time.Now(),
+		)
+		return p
+	}
+
+	o := &statsDOutput{prefix: "sys", last: make(map[string]map[string]float64)}
+	if lines, _ := o.format(newNetwork(100)); len(lines) != 0 {
+		t.Error("Counters should be sent from their second collection on, got", lines)
+	}
+	lines, _ := o.format(newNetwork(142))
+	if len(lines) != 1 || lines[0] != "sys.network.koala_example_com.eth0.recv_bytes:42|c" {
+		t.Error("Unexpected counter", lines)
+	}
+
+	o = &statsDOutput{dogStatsD: true, last: make(map[string]map[string]float64)}
+	lines, _ = o.format(newTestLoadPoint())
+	expected := []string{
+		"load.fifteen:2|g|#fqdn:koala_example",
+		"load.five:1|g|#fqdn:koala_example",
+		"load.one:0.5|g|#fqdn:koala_example",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Error("Unexpected gauges", lines)
+	}
+}
+
+// TestStatsDCounterDelta uses the unsigned fields of the cpu collector,
+// which are neither diffed nor numbers in the point.
+func TestStatsDCounterDelta(t *testing.T) {
+	newCPU := func(idle uint64) *influxClient.Point {
+		p, _ := influxClient.NewPoint(
+			"cpu",
+			map[string]string{"cpuid": "all"},
+			map[string]interface{}{"idle": idle},
+			time.Now(),
+		)
+		return p
+	}
+
+	o := &statsDOutput{last: make(map[string]map[string]float64)}
+	var lines []string
+	for _, idle := range []uint64{1000, 1100, 1250} {
+		l, err := o.format(newCPU(idle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l...)
+	}
+
+	expected := []string{"cpu.all.idle:100|c", "cpu.all.idle:150|c"}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Error("Expected differences of the cumulative idle time, got", lines)
+	}
+}
+
+func TestStatsDWrite(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	o, err := newStatsDOutput(conn.LocalAddr().String(), "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	if err := o.Write([]*influxClient.Point{newTestLoadPoint()}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, statsDPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(string(buf[:n]), "\n"); len(lines) != 3 {
+		t.Error("Expected 3 metrics in one datagram, got", lines)
+	}
+}
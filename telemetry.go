@@ -0,0 +1,196 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	influx "github.com/influxdata/influxdb/client/v2"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timedResult is a collectionResult along with the collector that produced
+// it and the time it took.
+type timedResult struct {
+	collectionResult
+	collector string
+	duration  time.Duration
+}
+
+// runCollector runs one collector and forwards its result to ch.
+func runCollector(cl collector, ch chan timedResult) {
+	start := time.Now()
+	res := make(chan collectionResult, 1)
+	cl.collect(res)
+	ch <- timedResult{<-res, cl.name, time.Since(start)}
+}
+
+type collectorStats struct {
+	duration  time.Duration
+	points    int
+	errors    int
+	lastError error
+}
+
+type outputStats struct {
+	latency   time.Duration
+	errors    int
+	lastWrite time.Time
+	lastError error
+}
+
+// telemetry keeps statistics of the reporter's own pipeline. Durations and
+// point counts are those of the last collection or write, errors are counted
+// since startup. It is shared with the health listener.
+type telemetry struct {
+	mutex      sync.Mutex
+	collectors map[string]*collectorStats
+	outputs    map[string]*outputStats
+	lastCycle  time.Time
+}
+
+func newTelemetry() *telemetry {
+	return &telemetry{
+		collectors: make(map[string]*collectorStats),
+		outputs:    make(map[string]*outputStats),
+	}
+}
+
+func (t *telemetry) collected(res timedResult) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.collectors[res.collector]
+	if !ok {
+		s = &collectorStats{}
+		t.collectors[res.collector] = s
+	}
+
+	s.duration = res.duration
+	s.points = 0
+	for _, p := range res.point {
+		if p != nil {
+			s.points++
+		}
+	}
+	s.lastError = res.err
+	if res.err != nil {
+		s.errors++
+	}
+}
+
+func (t *telemetry) written(o output, latency time.Duration, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.outputs[o.String()]
+	if !ok {
+		s = &outputStats{}
+		t.outputs[o.String()] = s
+	}
+
+	s.latency = latency
+	s.lastError = err
+	if err != nil {
+		s.errors++
+	} else {
+		s.lastWrite = time.Now()
+	}
+}
+
+// cycleDone records that a collection has been handed to the outputs.
+func (t *telemetry) cycleDone() {
+	t.mutex.Lock()
+	t.lastCycle = time.Now()
+	t.mutex.Unlock()
+}
+
+// points returns the influxdb_reporter measurement: one point per collector,
+// one per output that has been written to, and one for the process itself.
+func (t *telemetry) points(outputs []output) []*influx.Point {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var points []*influx.Point
+
+	var names []string
+	for name := range t.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := t.collectors[name]
+		points = append(points, newPoint(
+			"influxdb_reporter",
+			map[string]string{"version": applicationVersion, "collector": name},
+			map[string]interface{}{
+				"duration_ms": durationMillis(s.duration),
+				"points":      s.points,
+				"errors":      s.errors,
+			},
+		))
+	}
+
+	for _, o := range outputs {
+		s, ok := t.outputs[o.String()]
+		if !ok {
+			continue
+		}
+		fields := map[string]interface{}{
+			"latency_ms": durationMillis(s.latency),
+			"errors":     s.errors,
+		}
+		if influxOut, ok := o.(*influxOutput); ok {
+			status := influxOut.status()
+			fields["connected"] = status.Connected
+			fields["buffered"] = status.Buffered
+			fields["dropped"] = status.Dropped
+		}
+		points = append(points, newPoint(
+			"influxdb_reporter",
+			map[string]string{"version": applicationVersion, "output": o.String()},
+			fields,
+		))
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	points = append(points, newPoint(
+		"influxdb_reporter",
+		map[string]string{"version": applicationVersion},
+		map[string]interface{}{
+			"diff_cache_size": len(lastSeries),
+			"goroutines":      runtime.NumGoroutine(),
+			"heap_alloc":      int64(mem.HeapAlloc),
+			"heap_inuse":      int64(mem.HeapInuse),
+			"heap_objects":    int64(mem.HeapObjects),
+		},
+	))
+
+	return points
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
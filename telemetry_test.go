@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"testing"
+	"time"
+)
+
+type errorOutput struct{}
+
+func (o errorOutput) String() string {
+	return "broken"
+}
+
+func (o errorOutput) Write(points []*influxClient.Point) error {
+	return errors.New("write failed")
+}
+
+func (o errorOutput) Close() error {
+	return nil
+}
+
+func TestTelemetry(t *testing.T) {
+	stats := newTelemetry()
+	ch := make(chan timedResult, 2)
+	go runCollector(collector{name: "test_ok", collect: func(ch chan collectionResult) {
+		ch <- collectionResult{newTestPoints(t, 3), nil}
+	}}, ch)
+	go runCollector(collector{name: "test_err", collect: func(ch chan collectionResult) {
+		ch <- collectionResult{nil, errors.New("collect failed")}
+	}}, ch)
+	stats.collected(<-ch)
+	stats.collected(<-ch)
+
+	outputs := []output{errorOutput{}}
+	stats.written(outputs[0], 5*time.Millisecond, outputs[0].Write(nil))
+
+	points := stats.points(outputs)
+	if len(points) != 4 {
+		t.Fatal("Expected 4 points, got", len(points))
+	}
+	for _, p := range points {
+		if p.Name() != "influxdb_reporter" || p.Tags()["version"] != applicationVersion {
+			t.Error("Unexpected measurement or version:", p)
+		}
+	}
+
+	fields, _ := points[0].Fields()
+	if points[0].Tags()["collector"] != "test_err" || fields["errors"] != int64(1) || fields["points"] != int64(0) {
+		t.Error("Unexpected stats of failing collector:", points[0])
+	}
+	fields, _ = points[1].Fields()
+	if points[1].Tags()["collector"] != "test_ok" || fields["errors"] != int64(0) || fields["points"] != int64(3) {
+		t.Error("Unexpected stats of collector:", points[1])
+	}
+	fields, _ = points[2].Fields()
+	if points[2].Tags()["output"] != "broken" || fields["errors"] != int64(1) || fields["latency_ms"] != 5.0 {
+		t.Error("Unexpected stats of output:", points[2])
+	}
+	fields, _ = points[3].Fields()
+	if fields["goroutines"] == nil || fields["heap_alloc"] == nil || fields["diff_cache_size"] == nil {
+		t.Error("Expected runtime stats, got", points[3])
+	}
+	if _, ok := fields["heap_alloc"].(int64); !ok {
+		t.Errorf("Expected heap_alloc to be an integer field, got %T", fields["heap_alloc"])
+	}
+}
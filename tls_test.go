@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/pem"
+	influxClient "github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "reporter")
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, pemData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ping := func(ca, serverName string, insecure bool) error {
+		tlsConfig, err := newTLSConfig(ca, "", "", serverName, insecure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, _ := influxClient.NewHTTPClient(influxClient.HTTPConfig{Addr: server.URL, TLSConfig: tlsConfig})
+		_, _, err = client.Ping(time.Second)
+		return err
+	}
+
+	if err := ping("", "", false); err == nil {
+		t.Error("Unknown CA should be rejected")
+	}
+	if err := ping(ca, "", false); err != nil {
+		t.Error("Pinned CA should be accepted:", err)
+	}
+	if err := ping(ca, "influxdb.invalid", false); err == nil {
+		t.Error("Wrong server name should be rejected")
+	}
+	if err := ping("", "", true); err != nil {
+		t.Error("Verification should be skipped:", err)
+	}
+
+	if _, err := newTLSConfig(filepath.Join(dir, "missing.pem"), "", "", "", false); err == nil {
+		t.Error("Missing CA bundle should be reported")
+	}
+}
+
+func TestTLSFlagsRequireSSL(t *testing.T) {
+	defer func(ssl bool, ca string) {
+		sslFlag, tlsCAFlag = ssl, ca
+	}(sslFlag, tlsCAFlag)
+
+	sslFlag, tlsCAFlag = false, "ca.pem"
+	if err := checkFlags(); err == nil {
+		t.Error("TLS options without -ssl should be rejected")
+	}
+
+	sslFlag = true
+	if err := checkFlags(); err != nil {
+		t.Error("TLS options with -ssl should be accepted:", err)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// collectorTimeout bounds the time validate waits for a collector, e.g. if
+// a mount point hangs.
+var collectorTimeout = 10 * time.Second
+
+// validation is the outcome of one check of the validate command.
+type validation struct {
+	name   string
+	detail string
+	err    error
+}
+
+// validate checks the flags, the collectors' sources and the InfluxDB
+// servers without sending any point, prints a report to w and returns false
+// if any check failed.
+func validate(w io.Writer) bool {
+	var results []validation
+	results = append(results, validation{"flags", "", checkFlags()})
+
+	collectList, err := buildCollectionList()
+	if err != nil {
+		results = append(results, validation{"collect", "", err})
+	}
+	for _, cl := range collectList {
+		results = append(results, validateCollector(cl))
+	}
+
+	if influxEnabled() {
+		results = append(results, validateInflux()...)
+	}
+
+	ok := true
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			ok = false
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", r.name, r.err)
+		case r.detail != "":
+			fmt.Fprintf(w, "[ OK ] %s: %s\n", r.name, r.detail)
+		default:
+			fmt.Fprintf(w, "[ OK ] %s\n", r.name)
+		}
+	}
+	return ok
+}
+
+// validateCollector runs a collector to check it can read its sources.
+// Diffed collectors only report data from their second collection on.
+func validateCollector(cl collector) validation {
+	v := validation{name: "collector " + cl.name}
+
+	runs := 1
+	if cl.diffed {
+		runs = 2
+	}
+
+	ch := make(chan timedResult, 1)
+	for i := 0; i < runs; i++ {
+		go runCollector(cl, ch)
+
+		select {
+		case res := <-ch:
+			switch {
+			case res.err != nil:
+				v.err = res.err
+				return v
+			case len(res.point) == 0:
+				v.err = errors.New("no data, unexpected source format")
+			default:
+				v.err = nil
+				v.detail = fmt.Sprintf("%d series in %s", len(res.point), res.duration)
+			}
+		case <-time.After(collectorTimeout):
+			v.err = fmt.Errorf("no result after %s", collectorTimeout)
+			return v
+		}
+	}
+	return v
+}
+
+// validateInflux checks each configured host: the connection, and whether
+// the database can be written to.
+func validateInflux() []validation {
+	username, password, err := credentials()
+	if err != nil {
+		return []validation{{"credentials", "", err}}
+	}
+
+	var results []validation
+	for _, host := range strings.Split(hostFlag, ",") {
+		host = strings.TrimSpace(host)
+		v := validation{name: "influxdb " + host}
+
+		e, err := newEndpoint(host, username, password)
+		if err != nil {
+			v.err = err
+			results = append(results, v)
+			continue
+		}
+
+		if _, version, err := e.client.Ping(5 * time.Second); err != nil {
+			v.err = fmt.Errorf("cannot connect: %v", err)
+		} else if v.detail, v.err = validateWrite(e); v.err == nil && version != "" {
+			v.detail = "version " + version + ", " + v.detail
+		}
+		e.client.Close()
+		results = append(results, v)
+	}
+	return results
+}
+
+// validateWrite checks the permissions with an empty write. Without
+// -create-database, the database must already exist.
+func validateWrite(e *endpoint) (string, error) {
+	if influxAPIFlag == "v1" {
+		// Users without admin rights can't list databases, the write tells
+		// whether they can use it
+		exists, err := queryContains(e.client, "SHOW DATABASES", "", databaseFlag)
+		if err == nil && !exists {
+			if createDatabaseFlag {
+				return fmt.Sprintf("database %s will be created", databaseFlag), nil
+			}
+			return "", fmt.Errorf("database %s does not exist, use -create-database", databaseFlag)
+		}
+	}
+
+	err := send(e.client, nil)
+	// InfluxDB 2 checks the token before rejecting the empty body
+	if err != nil && influxAPIFlag == "v2" && strings.Contains(err.Error(), "requires points") {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot write: %v", err)
+	}
+
+	if influxAPIFlag == "v2" {
+		return fmt.Sprintf("can write to bucket %s", bucketFlag), nil
+	}
+	return fmt.Sprintf("can write to database %s", databaseFlag), nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2017 Beate Ottenwälder
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	defer func(collect, host, database string) {
+		collectFlag, hostFlag, databaseFlag = collect, host, database
+	}(collectFlag, hostFlag, databaseFlag)
+
+	var writes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			w.Header().Set("X-Influxdb-Version", "1.8.10")
+			w.WriteHeader(http.StatusNoContent)
+		case "/query":
+			w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"databases","columns":["name"],"values":[["metrics"]]}]}]}`))
+		case "/write":
+			writes++
+			if r.URL.Query().Get("db") != "metrics" {
+				http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	collectFlag = "cpu,uptime"
+	hostFlag = strings.TrimPrefix(server.URL, "http://")
+	databaseFlag = "metrics"
+
+	var report bytes.Buffer
+	if !validate(&report) {
+		t.Error("Expected valid configuration, got", report.String())
+	}
+	if !strings.Contains(report.String(), "[ OK ] influxdb "+hostFlag+": version 1.8.10, can write to database metrics") {
+		t.Error("Expected InfluxDB check, got", report.String())
+	}
+	if writes != 1 {
+		t.Error("Expected 1 empty write, got", writes)
+	}
+
+	collectFlag = "uptime,foo"
+	databaseFlag = "missing"
+	report.Reset()
+	if validate(&report) {
+		t.Error("Expected invalid configuration, got", report.String())
+	}
+	for _, expected := range []string{
+		"[FAIL] collect: unknown collect option `foo'",
+		"[FAIL] influxdb " + hostFlag + ": database missing does not exist",
+	} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("Expected %q in report, got %s", expected, report.String())
+		}
+	}
+}